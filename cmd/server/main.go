@@ -2,16 +2,189 @@
 package main
 
 import (
-	"log"
+	"log/slog"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"telegram_moderator/internal/breaker"
+	"telegram_moderator/internal/clock"
 	"telegram_moderator/internal/config"
 	"telegram_moderator/internal/http"
+	"telegram_moderator/internal/i18n"
+	"telegram_moderator/internal/logging"
+	"telegram_moderator/internal/metrics"
+	"telegram_moderator/internal/moderator"
+	"telegram_moderator/internal/ratelimit"
+	"telegram_moderator/internal/storage"
+	"telegram_moderator/internal/telegram"
+	"telegram_moderator/internal/tracing"
+	"time"
 )
 
+// traceRingSize is how many traced events are kept in memory for /debug/events
+const traceRingSize = 1000
+
+// setupLogging makes the redacting JSON logger the default, the log package writes through it as well.
+func setupLogging(token string) {
+	salt := config.GetEnv("LOG_HASH_SALT", "")
+	if salt == "" {
+		// hashes then correlate within one run only
+		salt = strconv.FormatInt(rand.New(rand.NewSource(time.Now().UnixNano())).Int63(), 36)
+	}
+
+	slog.SetDefault(logging.New(os.Stderr, logging.Options{
+		Level:     logging.ParseLevel(config.GetEnv("LOG_LEVEL", "info")),
+		Secrets:   []string{token},
+		HashUsers: config.GetEnv("LOG_HASH_USERS", "false") == "true",
+		HashText:  config.GetEnv("LOG_HASH_TEXT", "false") == "true",
+		Salt:      salt,
+	}))
+}
+
+// setupTracing builds the tracer from DEBUG_SINKS and turns it on for DEBUG_TRACE_CHATS.
+// The ring buffer it returns is nil unless the ring sink is used.
+func setupTracing(client telegram.Client) (*tracing.Tracer, *tracing.Ring) {
+	var sinks []tracing.Sink
+	var ring *tracing.Ring
+
+	for _, name := range strings.Split(config.GetEnv("DEBUG_SINKS", "log,ring"), ",") {
+		switch strings.TrimSpace(name) {
+		case "log":
+			sinks = append(sinks, tracing.LogSink{})
+		case "ring":
+			ring = tracing.NewRing(traceRingSize)
+			sinks = append(sinks, ring)
+		case "chat":
+			chatId := chatIdEnv("DEBUG_CHAT_ID")
+			if chatId == 0 {
+				slog.Warn("DEBUG_CHAT_ID is not set, the chat sink is disabled")
+				continue
+			}
+			sinks = append(sinks, tracing.NewChatSink(client, chatId))
+		case "":
+		default:
+			slog.Warn("Unknown debug sink", "sink", name)
+		}
+	}
+
+	tracer := tracing.NewTracer(sinks...)
+	for _, value := range strings.Split(config.GetEnv("DEBUG_TRACE_CHATS", ""), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		chatId, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			slog.Warn("Invalid chat id in DEBUG_TRACE_CHATS", "value", value)
+			continue
+		}
+		tracer.SetEnabled(chatId, true)
+	}
+
+	return tracer, ring
+}
+
+// chatIdEnv reads a chat id, zero when it is not set or invalid.
+func chatIdEnv(key string) int64 {
+	value := config.GetEnv(key, "")
+	if value == "" {
+		return 0
+	}
+
+	chatId, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		slog.Warn("Invalid chat id", "key", key, "value", value)
+		return 0
+	}
+
+	return chatId
+}
+
+func fatal(message string, err error) {
+	slog.Error(message, "error", err)
+	os.Exit(1)
+}
+
+// flushOnSignal writes the pending scheduled jobs before the process exits on SIGINT or SIGTERM.
+func flushOnSignal(mod *moderator.Moderator) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+
+	if err := mod.Flush(); err != nil {
+		fatal("Failed to save scheduled jobs", err)
+	}
+	os.Exit(0)
+}
+
 func main() {
 	config.LoadEnv()
+	setupLogging(config.GetEnv("TELEGRAM_BOT_API_TOKEN", "default"))
+
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := runReplay(os.Args[2:]); err != nil {
+			fatal("Replay failed", err)
+		}
+		return
+	}
 
 	port := config.GetEnv("LOCAL_PORT_FOR_WEBHOOK", "8443")
-	log.Printf("Starting server on :%s", port)
+	token := config.GetEnv("TELEGRAM_BOT_API_TOKEN", "default")
+
+	store, err := storage.NewStore(config.GetEnv("DATA_DIR", "data"))
+	if err != nil {
+		fatal("Failed to open storage", err)
+	}
+
+	settings, err := config.LoadChatSettings(config.GetEnv("CHAT_SETTINGS_PATH", "chats.json"))
+	if err != nil {
+		fatal("Failed to load chat settings", err)
+	}
+
+	catalog, err := i18n.Load()
+	if err != nil {
+		fatal("Failed to load translations", err)
+	}
+
+	// the breaker fails fast before a call waits for the rate limiter, the metrics see every request
+	client := breaker.New(ratelimit.NewClient(metrics.NewClient(telegram.NewHTTPClient(token, "")), ratelimit.DefaultLimits), breaker.DefaultSettings)
+	tracer, traces := setupTracing(client)
+
+	mod, err := moderator.New(moderator.Config{
+		Client:           client,
+		Clock:            clock.Real{},
+		Random:           rand.New(rand.NewSource(time.Now().UnixNano())),
+		Store:            store,
+		Settings:         settings,
+		Catalog:          catalog,
+		VerifiedUserTTL:  config.GetDurationEnv("VERIFIED_USER_TTL", 30*24*time.Hour),
+		AppealWindow:     config.GetDurationEnv("APPEAL_WINDOW", 7*24*time.Hour),
+		BotUsername:      config.GetEnv("BOT_USERNAME", ""),
+		WebAppDirectLink: config.GetEnv("WEBAPP_DIRECT_LINK", ""),
+		Tracer:           tracer,
+
+		WebhookURL:           config.GetEnv("WEBHOOK_URL", ""),
+		WebhookCheckInterval: config.GetDurationEnv("WEBHOOK_CHECK_INTERVAL", 5*time.Minute),
+		OwnerChatID:          chatIdEnv("OWNER_CHAT_ID"),
+		DebugChatID:          chatIdEnv("DEBUG_CHAT_ID"),
+	})
+	if err != nil {
+		fatal("Failed to load moderation state", err)
+	}
+	metrics.RegisterSource(mod)
+	mod.Start(make(chan struct{}))
+	go flushOnSignal(mod)
+
+	slog.Info("Starting server", "port", port)
 
-	http.StartServer(port)
+	server := http.NewServer(mod, token)
+	server.Traces = traces
+	server.AdminToken = config.GetEnv("ADMIN_TOKEN", "")
+	server.Breaker = client
+	if address := config.GetEnv("METRICS_ADDR", ""); address != "" {
+		go http.StartMetricsServer(address)
+	}
+	http.StartServer(port, server)
 }
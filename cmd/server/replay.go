@@ -0,0 +1,65 @@
+// cmd/server/replay.go
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"telegram_moderator/internal/config"
+	"telegram_moderator/internal/replay"
+)
+
+// runReplay is the replay subcommand:
+//
+//	telegram-moderator replay [-chats chats.json] [-members 1,2] [-tlds tld.json] [-seed 1] updates.jsonl
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	chatsPath := flags.String("chats", config.GetEnv("CHAT_SETTINGS_PATH", "chats.json"), "chat settings file")
+	members := flags.String("members", "", "comma separated user ids that are members of the chats")
+	tldsPath := flags.String("tlds", "", "TLD list file, fetched from the network when empty")
+	seed := flags.Int64("seed", 1, "seed of the verification questions")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: replay [flags] updates.jsonl")
+	}
+
+	settings, err := config.LoadChatSettings(*chatsPath)
+	if err != nil {
+		return fmt.Errorf("loading chat settings: %v", err)
+	}
+
+	cfg := replay.Config{Settings: settings, Members: map[int64]bool{}, Seed: *seed}
+	for _, id := range strings.Split(*members, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		userId, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid member id %q", id)
+		}
+		cfg.Members[userId] = true
+	}
+
+	if *tldsPath != "" {
+		data, err := os.ReadFile(*tldsPath)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &cfg.TLDs); err != nil {
+			return fmt.Errorf("parsing TLD list: %v", err)
+		}
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return replay.Run(file, os.Stdout, cfg)
+}
@@ -0,0 +1,164 @@
+// internal/appeal/appeal.go
+
+package appeal
+
+import (
+	"fmt"
+	"sync"
+	"telegram_moderator/internal/storage"
+	"time"
+)
+
+const storeName = "appeals"
+
+type Status string
+
+const (
+	// StatusOpen cases can be appealed from the private chat with the bot
+	StatusOpen Status = "open"
+	// StatusAwaitingReason cases wait for the user to explain the appeal
+	StatusAwaitingReason Status = "awaiting_reason"
+	// StatusSubmitted cases wait for an admin decision
+	StatusSubmitted Status = "submitted"
+	StatusApproved  Status = "approved"
+	StatusRejected  Status = "rejected"
+)
+
+// Case is a deleted message its author may appeal against.
+type Case struct {
+	ID            string    `json:"id"`
+	ChatID        int64     `json:"chat_id"`
+	ChatTitle     string    `json:"chat_title"`
+	UserID        int64     `json:"user_id"`
+	Username      string    `json:"username"`
+	FirstName     string    `json:"first_name"`
+	LanguageCode  string    `json:"language_code"`
+	PostMessageID int64     `json:"post_message_id"`
+	Text          string    `json:"text"`
+	URLs          []string  `json:"urls"`
+	DeletedAt     time.Time `json:"deleted_at"`
+	// Penalty is the escalation action applied together with the deletion
+	Penalty string `json:"penalty"`
+	Status  Status `json:"status"`
+	// Reason is the explanation the user gave for the appeal
+	Reason string `json:"reason"`
+}
+
+func CaseID(chatId int64, messageId int64) string {
+	return fmt.Sprintf("%d_%d", chatId, messageId)
+}
+
+// Registry keeps the appealable cases for the appeal window.
+type Registry struct {
+	mu     sync.Mutex
+	store  *storage.Store
+	window time.Duration
+	cases  map[string]Case
+}
+
+func NewRegistry(store *storage.Store, window time.Duration) (*Registry, error) {
+	r := &Registry{
+		store:  store,
+		window: window,
+		cases:  map[string]Case{},
+	}
+
+	if err := store.Load(storeName, &r.cases); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Registry) Open(c Case) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c.Status = StatusOpen
+	r.cases[c.ID] = c
+	return r.store.Save(storeName, r.cases)
+}
+
+func (r *Registry) Get(id string) (Case, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.cases[id]
+	return c, ok
+}
+
+// Appealable reports whether the case may still be appealed by its author.
+func (r *Registry) Appealable(c Case, now time.Time) bool {
+	if c.Status != StatusOpen && c.Status != StatusAwaitingReason {
+		return false
+	}
+
+	return r.window <= 0 || now.Before(c.DeletedAt.Add(r.window))
+}
+
+// AwaitingReason returns the case the user started to appeal in the private chat.
+func (r *Registry) AwaitingReason(userId int64) (Case, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.cases {
+		if c.UserID == userId && c.Status == StatusAwaitingReason {
+			return c, true
+		}
+	}
+
+	return Case{}, false
+}
+
+// SetStatus moves the case to status if it is currently in one of the from statuses.
+func (r *Registry) SetStatus(id string, status Status, reason string, from ...Status) (Case, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.cases[id]
+	if !ok {
+		return c, false, nil
+	}
+
+	allowed := len(from) == 0
+	for _, s := range from {
+		if c.Status == s {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return c, false, nil
+	}
+
+	c.Status = status
+	if reason != "" {
+		c.Reason = reason
+	}
+	r.cases[id] = c
+
+	return c, true, r.store.Save(storeName, r.cases)
+}
+
+// Prune drops cases that can't be appealed anymore and were decided or never appealed.
+func (r *Registry) Prune(now time.Time) error {
+	if r.window <= 0 {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pruned := false
+	for id, c := range r.cases {
+		if c.Status != StatusSubmitted && !now.Before(c.DeletedAt.Add(r.window)) {
+			delete(r.cases, id)
+			pruned = true
+		}
+	}
+
+	if !pruned {
+		return nil
+	}
+
+	return r.store.Save(storeName, r.cases)
+}
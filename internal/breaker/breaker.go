@@ -0,0 +1,202 @@
+// internal/breaker/breaker.go
+
+// Package breaker stops calling the Bot API for a while when it keeps failing, so the
+// handlers fail fast instead of piling up on a degraded server.
+package breaker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"telegram_moderator/internal/clock"
+	"telegram_moderator/internal/metrics"
+	"telegram_moderator/internal/telegram"
+	"time"
+)
+
+// State of the breaker, the values are exported as the circuit state metric.
+type State int
+
+const (
+	Closed   State = 0
+	HalfOpen State = 1
+	Open     State = 2
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	}
+
+	return "open"
+}
+
+// ErrOpen is returned without calling the API while the breaker is open. It is not an
+// API error, so the moderation actions failing with it are retried later.
+var ErrOpen = errors.New("circuit breaker of the Bot API is open")
+
+// Settings of a Breaker.
+type Settings struct {
+	// Failures is the number of consecutive failures that opens the breaker
+	Failures int
+	// Cooldown is how long the breaker stays open before a trial call is let through
+	Cooldown time.Duration
+	// Clock times the cooldown, nil is the wall clock
+	Clock clock.Clock
+}
+
+var DefaultSettings = Settings{
+	Failures: 5,
+	Cooldown: 30 * time.Second,
+}
+
+// Breaker is a Bot API client that opens after consecutive failures of the wrapped client.
+// Only failures of the server count: no response or a 5xx. Rejected calls and rate limits don't.
+type Breaker struct {
+	next     telegram.Client
+	settings Settings
+	clock    clock.Clock
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	// trial is set while the single call of the half-open state is running
+	trial bool
+	// generation changes whenever the breaker opens, results of calls started before are stale
+	generation int
+	// lastErr is the failure that opened the breaker
+	lastErr error
+}
+
+// ticket is handed out to a call let through, its result counts only for the state it started in.
+type ticket struct {
+	trial      bool
+	generation int
+}
+
+func New(next telegram.Client, settings Settings) *Breaker {
+	clk := settings.Clock
+	if clk == nil {
+		clk = clock.Real{}
+	}
+
+	metrics.APICircuitState.Set(float64(Closed))
+	return &Breaker{next: next, settings: settings, clock: clk}
+}
+
+func (b *Breaker) Call(method string, params interface{}) (json.RawMessage, error) {
+	t, ok := b.allow()
+	if !ok {
+		metrics.APICircuitRejections.WithLabelValues(method).Inc()
+		return nil, fmt.Errorf("%s: %w", method, ErrOpen)
+	}
+
+	result, err := b.next.Call(method, params)
+	b.record(t, err)
+
+	return result, err
+}
+
+// State is the current state, an open breaker past its cooldown reports half-open.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && !b.clock.Now().Before(b.openedAt.Add(b.settings.Cooldown)) {
+		return HalfOpen
+	}
+
+	return b.state
+}
+
+// Check fails while the breaker is open, for the readiness probe.
+func (b *Breaker) Check() error {
+	if b.State() != Open {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return fmt.Errorf("open since %s after %v", b.openedAt.UTC().Format(time.RFC3339), b.lastErr)
+}
+
+func (b *Breaker) allow() (ticket, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Closed:
+		return ticket{generation: b.generation}, true
+	case Open:
+		if b.clock.Now().Before(b.openedAt.Add(b.settings.Cooldown)) {
+			return ticket{}, false
+		}
+		b.setState(HalfOpen)
+	}
+
+	// half-open lets a single trial call through
+	if b.trial {
+		return ticket{}, false
+	}
+	b.trial = true
+	return ticket{trial: true, generation: b.generation}, true
+}
+
+func (b *Breaker) record(t ticket, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if t.trial {
+		b.trial = false
+	} else if b.state != Closed || t.generation != b.generation {
+		// the call started before the breaker opened, only the trial decides now
+		return
+	}
+
+	if !serverFailure(err) {
+		b.failures = 0
+		if b.state != Closed {
+			slog.Info("Bot API circuit breaker closed")
+			b.setState(Closed)
+		}
+		return
+	}
+
+	b.failures++
+	if t.trial || b.failures >= b.settings.Failures {
+		if b.state != Open {
+			slog.Warn("Bot API circuit breaker opened", "failures", b.failures, "cooldown", b.settings.Cooldown.String(), "error", err)
+		}
+		b.lastErr = err
+		b.openedAt = b.clock.Now()
+		b.generation++
+		b.setState(Open)
+	}
+}
+
+func (b *Breaker) setState(state State) {
+	b.state = state
+	metrics.APICircuitState.Set(float64(state))
+}
+
+// serverFailure tells a failure of the Bot API server from a rejected request.
+func serverFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var apiErr *telegram.APIError
+	if !errors.As(err, &apiErr) {
+		return true
+	}
+
+	return apiErr.Code >= http.StatusInternalServerError
+}
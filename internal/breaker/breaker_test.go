@@ -0,0 +1,113 @@
+// internal/breaker/breaker_test.go
+
+package breaker
+
+import (
+	"encoding/json"
+	"errors"
+	"telegram_moderator/internal/clock"
+	"telegram_moderator/internal/telegram"
+	"testing"
+	"time"
+)
+
+// fakeAPI answers every call with err.
+type fakeAPI struct {
+	err   error
+	calls int
+}
+
+func (f *fakeAPI) Call(method string, params interface{}) (json.RawMessage, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return json.RawMessage("true"), nil
+}
+
+func TestBreaker(t *testing.T) {
+	networkError := errors.New("sendMessage: connection refused")
+	badRequest := &telegram.APIError{Method: "sendMessage", Code: 400, Description: "Bad Request: chat not found"}
+
+	api := &fakeAPI{}
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	b := New(api, Settings{Failures: 3, Cooldown: 30 * time.Second, Clock: clk})
+
+	steps := []struct {
+		name      string
+		err       error
+		advance   time.Duration
+		wantCall  bool
+		wantState State
+	}{
+		{name: "success", wantCall: true, wantState: Closed},
+		{name: "bad requests don't count", err: badRequest, wantCall: true, wantState: Closed},
+		{name: "first failure", err: networkError, wantCall: true, wantState: Closed},
+		{name: "second failure", err: networkError, wantCall: true, wantState: Closed},
+		{name: "third failure opens", err: networkError, wantCall: true, wantState: Open},
+		{name: "open fails fast", err: networkError, advance: 10 * time.Second, wantState: Open},
+		{name: "failed trial opens again", err: networkError, advance: 30 * time.Second, wantCall: true, wantState: Open},
+		{name: "still open", advance: 10 * time.Second, wantState: Open},
+		{name: "successful trial closes", advance: 30 * time.Second, wantCall: true, wantState: Closed},
+	}
+
+	for _, step := range steps {
+		clk.Advance(step.advance)
+		api.err = step.err
+		calls := api.calls
+
+		_, err := b.Call("sendMessage", nil)
+		if called := api.calls > calls; called != step.wantCall {
+			t.Fatalf("%s: called = %v, want %v", step.name, called, step.wantCall)
+		}
+		if !step.wantCall && !errors.Is(err, ErrOpen) {
+			t.Fatalf("%s: err = %v, want ErrOpen", step.name, err)
+		}
+		if state := b.State(); state != step.wantState {
+			t.Fatalf("%s: state = %s, want %s", step.name, state, step.wantState)
+		}
+		if (b.Check() != nil) != (step.wantState == Open) {
+			t.Fatalf("%s: check = %v", step.name, b.Check())
+		}
+	}
+}
+
+func TestStaleCalls(t *testing.T) {
+	networkError := errors.New("getChatMember: connection refused")
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	b := New(&fakeAPI{}, Settings{Failures: 1, Cooldown: 30 * time.Second, Clock: clk})
+
+	// two calls start while closed, the first one fails and opens the breaker
+	first, _ := b.allow()
+	slow, _ := b.allow()
+	b.record(first, networkError)
+	if b.State() != Open {
+		t.Fatalf("state = %s, want open", b.State())
+	}
+
+	clk.Advance(30 * time.Second)
+	trial, ok := b.allow()
+	if !ok || !trial.trial {
+		t.Fatal("no trial call after the cooldown")
+	}
+
+	// the slow call finishing during the trial neither closes the breaker nor lets a second trial through
+	b.record(slow, nil)
+	if b.State() != HalfOpen {
+		t.Errorf("state = %s after a stale success, want half-open", b.State())
+	}
+	if _, ok := b.allow(); ok {
+		t.Error("second trial call let through")
+	}
+
+	b.record(trial, nil)
+	if b.State() != Closed {
+		t.Fatalf("state = %s after the trial, want closed", b.State())
+	}
+
+	// a stale failure doesn't open the breaker again
+	b.record(slow, networkError)
+	if b.State() != Closed {
+		t.Errorf("state = %s after a stale failure, want closed", b.State())
+	}
+}
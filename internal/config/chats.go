@@ -0,0 +1,247 @@
+// internal/config/chats.go
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+const defaultChatKey = "default"
+
+// Duration is a time.Duration written as "30s", "12h" in the chat settings file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+type PenaltyAction string
+
+const (
+	PenaltyDelete PenaltyAction = "delete"
+	PenaltyMute   PenaltyAction = "mute"
+	PenaltyKick   PenaltyAction = "kick"
+	PenaltyBan    PenaltyAction = "ban"
+)
+
+// PenaltyStep is applied on top of the message deletion when a user fails the verification.
+type PenaltyStep struct {
+	Action PenaltyAction `json:"action"`
+	// Duration limits mute and kick, zero means forever for mute
+	Duration Duration `json:"duration"`
+}
+
+type ChatSettings struct {
+	// Escalation holds the penalty for the first, second, ... strike, the last step repeats
+	Escalation []PenaltyStep `json:"escalation"`
+	// StrikeDecay is how long it takes for one strike to be forgotten, zero keeps strikes forever
+	StrikeDecay Duration `json:"strike_decay"`
+	// LogChatID is the chat where moderation actions are recorded, zero disables the log
+	LogChatID int64 `json:"log_chat_id"`
+	// Review holds failed verifications in the log chat for an admin decision instead of punishing right away
+	Review bool `json:"review"`
+	// ReviewExpiry is how long an item waits for a decision, zero waits forever
+	ReviewExpiry Duration `json:"review_expiry"`
+	// ReviewExpiryAction is the decision taken for expired items
+	ReviewExpiryAction ReviewDecision `json:"review_expiry_action"`
+	// ReportTemplate is the text/template of the public report about a deleted message, in Telegram HTML.
+	// Empty uses the translated default of the chat language.
+	ReportTemplate string `json:"report_template"`
+	// SuppressReports disables the public report, the moderation log still gets the record
+	SuppressReports bool `json:"suppress_reports"`
+	// Language is the language of the bot texts in the chat
+	Language string `json:"language"`
+	// UseUserLanguage addresses users in their Telegram language when it is available
+	UseUserLanguage bool `json:"use_user_language"`
+	// CleanupTTL deletes reports, verification questions and command replies of the bot
+	// after this time, zero keeps them
+	CleanupTTL Duration `json:"cleanup_ttl"`
+	// Shadow only records what the bot would do in the moderation log, nothing is sent,
+	// deleted or restricted in the chat
+	Shadow bool `json:"shadow"`
+}
+
+type ReviewDecision string
+
+const (
+	ReviewApprove ReviewDecision = "approve"
+	ReviewDelete  ReviewDecision = "delete"
+	ReviewBan     ReviewDecision = "ban"
+)
+
+// Penalty returns the escalation step for the given strike number starting at 1.
+func (s ChatSettings) Penalty(strike int) PenaltyStep {
+	if len(s.Escalation) == 0 || strike < 1 {
+		return PenaltyStep{Action: PenaltyDelete}
+	}
+
+	if strike > len(s.Escalation) {
+		strike = len(s.Escalation)
+	}
+
+	return s.Escalation[strike-1]
+}
+
+var builtinChatSettings = ChatSettings{
+	Escalation: []PenaltyStep{
+		{Action: PenaltyDelete},
+	},
+	StrikeDecay:        Duration(7 * 24 * time.Hour),
+	ReviewExpiry:       Duration(24 * time.Hour),
+	ReviewExpiryAction: ReviewDelete,
+	Language:           "en",
+}
+
+// ChatSettingsFile is the parsed chat settings file. Its "default" entry applies to every chat
+// and the entries keyed by chat id override only the fields they set.
+type ChatSettingsFile struct {
+	defaults ChatSettings
+	chats    map[int64]json.RawMessage
+}
+
+// LoadChatSettings reads the chat settings file. A missing file yields the built-in defaults.
+func LoadChatSettings(path string) (*ChatSettingsFile, error) {
+	file := &ChatSettingsFile{
+		defaults: builtinChatSettings.clone(),
+		chats:    map[int64]json.RawMessage{},
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return file, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	if raw, ok := entries[defaultChatKey]; ok {
+		if err := json.Unmarshal(raw, &file.defaults); err != nil {
+			return nil, err
+		}
+		if err := file.defaults.validate(); err != nil {
+			return nil, fmt.Errorf("default chat settings: %v", err)
+		}
+	}
+
+	for key, raw := range entries {
+		if key == defaultChatKey {
+			continue
+		}
+
+		chatId, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return nil, errors.New("chat settings key " + strconv.Quote(key) + " is not a chat id")
+		}
+
+		// check the entry up front so Chat never has to report an error
+		settings := file.defaults.clone()
+		if err := json.Unmarshal(raw, &settings); err != nil {
+			return nil, err
+		}
+		if err := settings.validate(); err != nil {
+			return nil, fmt.Errorf("chat settings of %d: %v", chatId, err)
+		}
+
+		file.chats[chatId] = raw
+	}
+
+	return file, nil
+}
+
+// clone copies the settings so that decoding an override into the copy never writes into the original.
+func (s ChatSettings) clone() ChatSettings {
+	if s.Escalation != nil {
+		s.Escalation = append([]PenaltyStep(nil), s.Escalation...)
+	}
+
+	return s
+}
+
+func (s ChatSettings) validate() error {
+	for i, step := range s.Escalation {
+		switch step.Action {
+		case PenaltyDelete, PenaltyMute, PenaltyKick, PenaltyBan:
+		default:
+			return fmt.Errorf("escalation step %d: unknown action %q", i+1, step.Action)
+		}
+	}
+
+	switch s.ReviewExpiryAction {
+	case ReviewApprove, ReviewDelete, ReviewBan:
+	default:
+		return fmt.Errorf("unknown review_expiry_action %q", s.ReviewExpiryAction)
+	}
+
+	if _, err := template.New("report").Parse(s.ReportTemplate); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ChatIDs lists the chats with their own entry in the settings file.
+func (f *ChatSettingsFile) ChatIDs() []int64 {
+	ids := make([]int64, 0, len(f.chats))
+	for chatId := range f.chats {
+		ids = append(ids, chatId)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids
+}
+
+// LogChatIDs lists every log chat of the settings file, the default one included.
+func (f *ChatSettingsFile) LogChatIDs() []int64 {
+	seen := map[int64]bool{}
+	ids := []int64{}
+	add := func(chatId int64) {
+		if chatId != 0 && !seen[chatId] {
+			seen[chatId] = true
+			ids = append(ids, chatId)
+		}
+	}
+
+	add(f.defaults.LogChatID)
+	for _, chatId := range f.ChatIDs() {
+		add(f.Chat(chatId).LogChatID)
+	}
+
+	return ids
+}
+
+func (f *ChatSettingsFile) Chat(chatId int64) ChatSettings {
+	settings := f.defaults.clone()
+
+	if raw, ok := f.chats[chatId]; ok {
+		json.Unmarshal(raw, &settings)
+	}
+
+	return settings
+}
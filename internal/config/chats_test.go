@@ -0,0 +1,103 @@
+// internal/config/chats_test.go
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadChatSettings(t *testing.T, content string) (*ChatSettingsFile, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "chats.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return LoadChatSettings(path)
+}
+
+func TestChatSettingsMerge(t *testing.T) {
+	file, err := loadChatSettings(t, `{
+		"default": {
+			"escalation": [{"action": "delete"}, {"action": "mute", "duration": "24h"}],
+			"language": "uk"
+		},
+		"-1001": {"escalation": [{"action": "ban"}], "suppress_reports": true},
+		"-1002": {"log_chat_id": -1009}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaultEscalation := []PenaltyStep{{Action: PenaltyDelete}, {Action: PenaltyMute, Duration: Duration(24 * time.Hour)}}
+
+	check := func(when string) {
+		tests := []struct {
+			chatId         int64
+			wantEscalation []PenaltyStep
+			wantLogChat    int64
+			wantSuppress   bool
+		}{
+			{chatId: -1001, wantEscalation: []PenaltyStep{{Action: PenaltyBan}}, wantSuppress: true},
+			{chatId: -1002, wantEscalation: defaultEscalation, wantLogChat: -1009},
+			{chatId: -1003, wantEscalation: defaultEscalation},
+		}
+
+		for _, test := range tests {
+			settings := file.Chat(test.chatId)
+			if !reflect.DeepEqual(settings.Escalation, test.wantEscalation) {
+				t.Errorf("%s: chat %d escalation = %v, want %v", when, test.chatId, settings.Escalation, test.wantEscalation)
+			}
+			if settings.LogChatID != test.wantLogChat {
+				t.Errorf("%s: chat %d log chat = %d, want %d", when, test.chatId, settings.LogChatID, test.wantLogChat)
+			}
+			if settings.SuppressReports != test.wantSuppress {
+				t.Errorf("%s: chat %d suppress reports = %v, want %v", when, test.chatId, settings.SuppressReports, test.wantSuppress)
+			}
+			if settings.Language != "uk" {
+				t.Errorf("%s: chat %d language = %q, want the default", when, test.chatId, settings.Language)
+			}
+		}
+	}
+
+	check("after load")
+	check("after reading every chat")
+
+	// changing the returned settings must not leak into the file
+	file.Chat(-1003).Escalation[0] = PenaltyStep{Action: PenaltyKick}
+	check("after changing the returned settings")
+}
+
+func TestChatSettingsValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "valid", content: `{"default": {"escalation": [{"action": "mute"}]}, "-1001": {"review_expiry_action": "approve"}}`},
+		{name: "unknown default action", content: `{"default": {"escalation": [{"action": "warn"}]}}`, wantErr: `unknown action "warn"`},
+		{name: "unknown chat action", content: `{"-1001": {"escalation": [{"action": "delete"}, {"action": "mutee"}]}}`, wantErr: `escalation step 2: unknown action "mutee"`},
+		{name: "unknown review expiry action", content: `{"-1001": {"review_expiry_action": "kick"}}`, wantErr: `unknown review_expiry_action "kick"`},
+		{name: "broken template", content: `{"default": {"report_template": "{{.Mention"}}`, wantErr: "template"},
+		{name: "key is not a chat id", content: `{"main": {}}`, wantErr: "is not a chat id"},
+	}
+
+	for _, test := range tests {
+		_, err := loadChatSettings(t, test.content)
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: error = %v, want %q", test.name, err, test.wantErr)
+		}
+	}
+}
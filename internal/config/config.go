@@ -2,9 +2,10 @@
 package config
 
 import (
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -12,14 +13,16 @@ import (
 func LoadEnv() {
 	cwd, err := os.Getwd()
 	if err != nil {
-		log.Fatalf("Error getting current working directory: %v", err)
+		slog.Error("Error getting current working directory", "error", err)
+		os.Exit(1)
 	}
 
 	envPath := filepath.Join(cwd, ".env")
 	if err := godotenv.Load(
 		envPath,
 	); err != nil {
-		log.Fatalf("Error loading .env file from %s: %v", envPath, err)
+		slog.Error("Error loading .env file", "path", envPath, "error", err)
+		os.Exit(1)
 	}
 }
 
@@ -29,3 +32,18 @@ func GetEnv(key string, defaultVal string) string {
 	}
 	return defaultVal
 }
+
+func GetDurationEnv(key string, defaultVal time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultVal
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("Invalid duration, using the default", "key", key, "value", value, "default", defaultVal.String(), "error", err)
+		return defaultVal
+	}
+
+	return duration
+}
@@ -0,0 +1,124 @@
+// internal/deadletter/deadletter.go
+
+// Package deadletter keeps the moderation actions that failed for good, for the admins to look at.
+package deadletter
+
+import (
+	"encoding/json"
+	"sync"
+	"telegram_moderator/internal/storage"
+	"time"
+)
+
+const storeName = "dead_letters"
+
+// retention is how long failed actions are kept
+const retention = 30 * 24 * time.Hour
+
+// Entry is a Bot API call of a moderation action that won't be retried.
+type Entry struct {
+	// Key identifies the action, a later action with the same key replaces the entry
+	Key      string          `json:"key"`
+	Method   string          `json:"method"`
+	ChatID   int64           `json:"chat_id"`
+	Params   json.RawMessage `json:"params"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	At       time.Time       `json:"at"`
+}
+
+// List is the persisted list of dead letters.
+type List struct {
+	mu      sync.Mutex
+	store   *storage.Store
+	entries []Entry
+}
+
+func NewList(store *storage.Store) (*List, error) {
+	l := &List{
+		store:   store,
+		entries: []Entry{},
+	}
+
+	if err := store.Load(storeName, &l.entries); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Add records the entry, replacing the one with the same key.
+func (l *List) Add(entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = append(l.without(func(e Entry) bool { return e.Key == entry.Key }), entry)
+	return l.store.Save(storeName, l.entries)
+}
+
+// Chat returns the entries of the chat, oldest first.
+func (l *List) Chat(chatId int64) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := make([]Entry, 0)
+	for _, entry := range l.entries {
+		if entry.ChatID == chatId {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// Len is the number of entries of all chats.
+func (l *List) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.entries)
+}
+
+// TakeChat removes the entries of the chat and returns them.
+func (l *List) TakeChat(chatId int64) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	taken := make([]Entry, 0)
+	for _, entry := range l.entries {
+		if entry.ChatID == chatId {
+			taken = append(taken, entry)
+		}
+	}
+	if len(taken) == 0 {
+		return taken, nil
+	}
+
+	l.entries = l.without(func(e Entry) bool { return e.ChatID == chatId })
+	return taken, l.store.Save(storeName, l.entries)
+}
+
+// Prune drops the entries older than the retention.
+func (l *List) Prune(now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	kept := l.without(func(e Entry) bool { return now.Sub(e.At) >= retention })
+	if len(kept) == len(l.entries) {
+		return nil
+	}
+
+	l.entries = kept
+	return l.store.Save(storeName, l.entries)
+}
+
+func (l *List) without(drop func(e Entry) bool) []Entry {
+	kept := make([]Entry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		if !drop(entry) {
+			kept = append(kept, entry)
+		}
+	}
+
+	return kept
+}
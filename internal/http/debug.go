@@ -0,0 +1,62 @@
+// internal/http/debug.go
+
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxTraceEvents caps the events returned by /debug/events at once
+const maxTraceEvents = 500
+
+// authorized checks the "Authorization: Bearer <AdminToken>" header of the request.
+func (s *Server) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return s.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) == 1
+}
+
+// debugEventsHandler lists the traced events kept in memory, optionally of one chat_id.
+// It answers 404 unless both the ring buffer and the admin token are configured.
+func (s *Server) debugEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Traces == nil || s.AdminToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !s.authorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var chatId int64
+	if value := r.URL.Query().Get("chat_id"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid chat_id", http.StatusBadRequest)
+			return
+		}
+		chatId = parsed
+	}
+
+	limit := maxTraceEvents
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if parsed < limit {
+			limit = parsed
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.Traces.Events(chatId, limit)); err != nil {
+		slog.Error("Error sending trace events", "error", err)
+	}
+}
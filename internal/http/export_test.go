@@ -0,0 +1,6 @@
+// internal/http/export_test.go
+
+package http
+
+// SignInitData lets the tests of the server sign Web App requests.
+var SignInitData = signInitData
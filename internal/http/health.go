@@ -0,0 +1,72 @@
+// internal/http/health.go
+
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+)
+
+// maxUpdatesInFlight is the number of updates handled at once above which the server is not ready
+const maxUpdatesInFlight = 64
+
+type checkResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// healthzHandler only tells that the process serves requests.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// readyzHandler runs the dependency checks and answers 503 when any of them fails.
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]error{
+		"token":   s.mod.CheckToken(),
+		"tlds":    s.mod.CheckTLDs(),
+		"storage": s.mod.CheckStorage(),
+		"updates": s.checkUpdatesInFlight(),
+	}
+	if s.Breaker != nil {
+		checks["telegram_api"] = s.Breaker.Check()
+	}
+
+	response := healthResponse{Status: "ok", Checks: map[string]checkResult{}}
+	status := http.StatusOK
+	for name, err := range checks {
+		if err != nil {
+			response.Checks[name] = checkResult{Error: err.Error()}
+			response.Status = "fail"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		response.Checks[name] = checkResult{OK: true}
+	}
+
+	writeHealth(w, status, response)
+}
+
+func (s *Server) checkUpdatesInFlight() error {
+	if inFlight := atomic.LoadInt64(&s.updatesInFlight); inFlight >= maxUpdatesInFlight {
+		return fmt.Errorf("%d updates in flight", inFlight)
+	}
+
+	return nil
+}
+
+func writeHealth(w http.ResponseWriter, status int, response healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Error sending response", "error", err)
+	}
+}
@@ -0,0 +1,386 @@
+// internal/http/server_test.go
+
+package http_test
+
+import (
+	"encoding/json"
+	"fmt"
+	nethttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"telegram_moderator/internal/clock"
+	"telegram_moderator/internal/config"
+	"telegram_moderator/internal/http"
+	"telegram_moderator/internal/i18n"
+	"telegram_moderator/internal/moderator"
+	"telegram_moderator/internal/storage"
+	"telegram_moderator/internal/telegram"
+	"telegram_moderator/internal/telegramtest"
+	"telegram_moderator/internal/tracing"
+	"telegram_moderator/pkg/models"
+	"testing"
+	"time"
+)
+
+const (
+	chatID   = int64(-100123)
+	authorID = int64(42)
+)
+
+// onesRandom asks 2 + 2 and shows a spoofed answer of 2.
+type onesRandom struct{}
+
+func (onesRandom) Intn(n int) int {
+	return 1 % n
+}
+
+func startBot(t *testing.T) *telegramtest.Server {
+	return startBotWithTLDs(t, map[string]string{"com": "Commercial"})
+}
+
+func startBotWithTLDs(t *testing.T, tlds map[string]string) *telegramtest.Server {
+	t.Helper()
+
+	fake, server := newBot(t, tlds, nil)
+	fake.Webhook = server.Handler()
+
+	return fake
+}
+
+// newBot returns the fake Bot API and a webhook server not yet attached to it.
+func newBot(t *testing.T, tlds map[string]string, tracer *tracing.Tracer) (*telegramtest.Server, *http.Server) {
+	t.Helper()
+
+	fake := telegramtest.NewServer()
+	t.Cleanup(fake.Close)
+
+	dir := t.TempDir()
+	store, err := storage.NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings, err := config.LoadChatSettings(dir + "/chats.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := i18n.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mod, err := moderator.New(moderator.Config{
+		Client:          telegram.NewHTTPClient(telegramtest.Token, fake.URL),
+		Clock:           clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)),
+		Random:          onesRandom{},
+		Store:           store,
+		Settings:        settings,
+		Catalog:         catalog,
+		TLDs:            tlds,
+		VerifiedUserTTL: time.Hour,
+		AppealWindow:    time.Hour,
+		Tracer:          tracer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake.WebhookSecret = http.WebhookSecretToken
+
+	return fake, http.NewServer(mod, telegramtest.Token)
+}
+
+func linkMessage(messageId int64) models.Update {
+	return models.Update{Message: &models.Message{
+		MessageID:   messageId,
+		From:        models.User{ID: authorID, FirstName: "Spammer"},
+		Chat:        models.Chat{ID: chatID, Type: "supergroup"},
+		MessageText: "visit example.com",
+	}}
+}
+
+func answerClick(questionMessageId int64, userMessageId int64, answer string) models.Update {
+	return models.Update{CallbackQuery: &models.CallbackQuery{
+		ID:   "callback",
+		From: models.User{ID: authorID},
+		Message: &models.Message{
+			MessageID:      questionMessageId,
+			Chat:           models.Chat{ID: chatID, Type: "supergroup"},
+			ReplyToMessage: &models.ReplyToMessage{MessageID: userMessageId},
+		},
+		Data: answer,
+	}}
+}
+
+func TestWebhookVerification(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		answer string
+		// noDeleteMessages makes the fake an older server without deleteMessages
+		noDeleteMessages bool
+		wantMethods      []string
+		// wantKept tells whether the link message survives
+		wantKept bool
+	}{
+		{
+			name:        "member",
+			status:      "member",
+			wantMethods: []string{"getChatMember"},
+			wantKept:    true,
+		},
+		{
+			name:        "correct answer",
+			answer:      "4",
+			wantMethods: []string{"getChatMember", "sendMessage", "deleteMessage", "answerCallbackQuery"},
+			wantKept:    true,
+		},
+		{
+			name:        "wrong answer",
+			answer:      "2",
+			wantMethods: []string{"getChatMember", "sendMessage", "deleteMessages", "sendMessage", "answerCallbackQuery"},
+		},
+		{
+			name:             "wrong answer without deleteMessages",
+			answer:           "2",
+			noDeleteMessages: true,
+			wantMethods:      []string{"getChatMember", "sendMessage", "deleteMessages", "deleteMessage", "deleteMessage", "sendMessage", "answerCallbackQuery"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := startBot(t)
+			fake.NoDeleteMessages = tt.noDeleteMessages
+			if tt.status != "" {
+				fake.SetMember(chatID, authorID, tt.status)
+			}
+
+			if err := fake.SendUpdate(linkMessage(1)); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.answer != "" {
+				questions := fake.CallsTo("sendMessage")
+				if len(questions) != 1 || questions[0].Int("reply_to_message_id") != 1 {
+					t.Fatalf("question calls = %v", questions)
+				}
+				// the fake numbers the sent messages from 1001
+				if err := fake.SendUpdate(answerClick(1001, 1, tt.answer)); err != nil {
+					t.Fatal(err)
+				}
+				if fake.HasMessage(chatID, 1001) {
+					t.Errorf("question was not deleted")
+				}
+			}
+
+			if got := fake.Methods(); !reflect.DeepEqual(got, tt.wantMethods) {
+				t.Errorf("methods = %v, want %v", got, tt.wantMethods)
+			}
+			if got := fake.HasMessage(chatID, 1); got != tt.wantKept {
+				t.Errorf("link message kept = %v, want %v", got, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestWebhookRejectsWrongSecret(t *testing.T) {
+	fake := startBot(t)
+	fake.WebhookSecret = "wrong"
+
+	if err := fake.SendUpdate(linkMessage(1)); err == nil {
+		t.Fatal("update with a wrong secret was accepted")
+	}
+	if calls := fake.Calls(); len(calls) != 0 {
+		t.Errorf("calls = %v, want none", calls)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name       string
+		tlds       map[string]string
+		wantStatus int
+		wantFailed string
+	}{
+		{name: "ready", tlds: map[string]string{"com": "Commercial"}, wantStatus: nethttp.StatusOK},
+		{name: "no TLD list", wantStatus: nethttp.StatusServiceUnavailable, wantFailed: "tlds"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := startBotWithTLDs(t, tt.tlds)
+
+			w := httptest.NewRecorder()
+			fake.Webhook.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			var response struct {
+				Checks map[string]struct {
+					OK bool `json:"ok"`
+				} `json:"checks"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			for name, check := range response.Checks {
+				if check.OK == (name == tt.wantFailed) {
+					t.Errorf("check %s ok = %v", name, check.OK)
+				}
+			}
+
+			// the token is validated once
+			fake.Webhook.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/readyz", nil))
+			if calls := fake.CallsTo("getMe"); len(calls) != 1 {
+				t.Errorf("getMe called %d times, want 1", len(calls))
+			}
+		})
+	}
+}
+
+func TestDebugEvents(t *testing.T) {
+	ring := tracing.NewRing(100)
+	tracer := tracing.NewTracer(ring)
+	tracer.SetEnabled(chatID, true)
+
+	fake, server := newBot(t, map[string]string{"com": "Commercial"}, tracer)
+	server.Traces = ring
+	server.AdminToken = "admin"
+	fake.Webhook = server.Handler()
+
+	if err := fake.SendUpdate(linkMessage(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		wantStatus int
+		wantEvents bool
+	}{
+		{name: "no token", path: "/debug/events", wantStatus: nethttp.StatusUnauthorized},
+		{name: "wrong token", path: "/debug/events", token: "wrong", wantStatus: nethttp.StatusUnauthorized},
+		{name: "traced chat", path: "/debug/events?chat_id=-100123", token: "admin", wantStatus: nethttp.StatusOK, wantEvents: true},
+		{name: "other chat", path: "/debug/events?chat_id=-1", token: "admin", wantStatus: nethttp.StatusOK},
+		{name: "bad chat id", path: "/debug/events?chat_id=x", token: "admin", wantStatus: nethttp.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			fake.Webhook.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code != nethttp.StatusOK {
+				return
+			}
+
+			var events []tracing.Event
+			if err := json.Unmarshal(w.Body.Bytes(), &events); err != nil {
+				t.Fatal(err)
+			}
+			if (len(events) > 0) != tt.wantEvents {
+				t.Errorf("events = %v, want some: %v", events, tt.wantEvents)
+			}
+		})
+	}
+
+}
+
+func TestMetricsNeedAdminToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		adminToken string
+		token      string
+		wantStatus int
+	}{
+		{name: "no admin token", token: "anything", wantStatus: nethttp.StatusNotFound},
+		{name: "no token", adminToken: "admin", wantStatus: nethttp.StatusUnauthorized},
+		{name: "wrong token", adminToken: "admin", token: "wrong", wantStatus: nethttp.StatusUnauthorized},
+		{name: "admin token", adminToken: "admin", token: "admin", wantStatus: nethttp.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, server := newBot(t, map[string]string{"com": "Commercial"}, nil)
+			server.AdminToken = tt.adminToken
+
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			server.Handler().ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code == nethttp.StatusOK && !strings.Contains(w.Body.String(), "telegram_moderator_") {
+				t.Errorf("no metrics in %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestWebAppVerify(t *testing.T) {
+	webAppRequest := func(userId int64, sessionMessageId int64, answer string) string {
+		initData := http.SignInitData(telegramtest.Token, url.Values{
+			"auth_date":   {strconv.FormatInt(time.Now().Unix(), 10)},
+			"start_param": {moderator.WebAppSessionParam(chatID, sessionMessageId)},
+			"user":        {fmt.Sprintf(`{"id":%d,"first_name":"Alice","language_code":"en"}`, userId)},
+		})
+		body, _ := json.Marshal(map[string]string{"init_data": initData, "answer": answer})
+		return string(body)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantCode   int
+		wantStatus string
+		wantText   string
+	}{
+		{name: "correct answer", body: webAppRequest(authorID, 1, "4"), wantCode: 200, wantStatus: "verified", wantText: "Thank you, you are verified."},
+		{name: "wrong answer", body: webAppRequest(authorID, 1, "2"), wantCode: 200, wantStatus: "rejected", wantText: "Wrong answer."},
+		{name: "other user", body: webAppRequest(authorID+1, 1, "4"), wantCode: 404, wantStatus: "expired", wantText: "This verification is no longer available."},
+		{name: "unknown session", body: webAppRequest(authorID, 9, "4"), wantCode: 404, wantStatus: "expired", wantText: "This verification is no longer available."},
+		{name: "body too large", body: `{"init_data": "` + strings.Repeat("a", 64<<10) + `"}`, wantCode: 413},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, server := newBot(t, map[string]string{"com": "Commercial"}, nil)
+			fake.Webhook = server.Handler()
+			if err := fake.SendUpdate(linkMessage(1)); err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			server.Handler().ServeHTTP(recorder, httptest.NewRequest("POST", "/webapp/verify", strings.NewReader(tt.body)))
+
+			if tt.wantStatus == "" {
+				if recorder.Code != tt.wantCode {
+					t.Errorf("code = %d, want %d", recorder.Code, tt.wantCode)
+				}
+				return
+			}
+
+			var response map[string]string
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("invalid response %q: %v", recorder.Body.String(), err)
+			}
+			if recorder.Code != tt.wantCode || response["status"] != tt.wantStatus || response["text"] != tt.wantText {
+				t.Errorf("response = %d %v, want %d %s %q", recorder.Code, response, tt.wantCode, tt.wantStatus, tt.wantText)
+			}
+		})
+	}
+}
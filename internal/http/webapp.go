@@ -0,0 +1,200 @@
+// internal/http/webapp.go
+
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"telegram_moderator/internal/logging"
+	"telegram_moderator/internal/moderator"
+	"telegram_moderator/pkg/models"
+	"time"
+)
+
+//go:embed static/webapp.html
+var webAppPage []byte
+
+// initData older than this is rejected to limit replaying of a captured payload
+const webAppInitDataMaxAge = 10 * time.Minute
+
+// maxWebAppRequestSize bounds the body of a Web App request, initData is a few KiB at most
+const maxWebAppRequestSize = 64 << 10
+
+type webAppInitData struct {
+	User       models.User
+	StartParam string
+	AuthDate   time.Time
+}
+
+type webAppRequest struct {
+	InitData string `json:"init_data"`
+	Answer   string `json:"answer"`
+}
+
+// validateWebAppInitData checks the initData signature as described in
+// https://core.telegram.org/bots/webapps#validating-data-received-via-the-mini-app
+func validateWebAppInitData(initData string, token string, now time.Time) (webAppInitData, error) {
+	var data webAppInitData
+
+	values, err := url.ParseQuery(initData)
+	if err != nil {
+		return data, err
+	}
+
+	receivedHash, err := hex.DecodeString(values.Get("hash"))
+	if err != nil || len(receivedHash) == 0 {
+		return data, errors.New("missing or malformed hash")
+	}
+
+	pairs := make([]string, 0, len(values))
+	for key := range values {
+		if key == "hash" {
+			continue
+		}
+		pairs = append(pairs, key+"="+values.Get(key))
+	}
+	sort.Strings(pairs)
+	dataCheckString := strings.Join(pairs, "\n")
+
+	secretKey := hmac.New(sha256.New, []byte("WebAppData"))
+	secretKey.Write([]byte(token))
+
+	expectedHash := hmac.New(sha256.New, secretKey.Sum(nil))
+	expectedHash.Write([]byte(dataCheckString))
+
+	if !hmac.Equal(expectedHash.Sum(nil), receivedHash) {
+		return data, errors.New("hash mismatch")
+	}
+
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil {
+		return data, errors.New("missing auth_date")
+	}
+	data.AuthDate = time.Unix(authDate, 0)
+	if now.Sub(data.AuthDate) > webAppInitDataMaxAge {
+		return data, errors.New("init data is expired")
+	}
+
+	if err := json.Unmarshal([]byte(values.Get("user")), &data.User); err != nil {
+		return data, fmt.Errorf("error parsing user: %v", err)
+	}
+	data.StartParam = values.Get("start_param")
+
+	return data, nil
+}
+
+func webAppPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(webAppPage)
+}
+
+// readWebAppRequest validates the request body and resolves the pending session it refers to.
+func (s *Server) readWebAppRequest(w http.ResponseWriter, r *http.Request) (webAppRequest, webAppInitData, int64, int64, bool) {
+	var request webAppRequest
+	var data webAppInitData
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return request, data, 0, 0, false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebAppRequestSize)
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+			return request, data, 0, 0, false
+		}
+		http.Error(w, "Error parsing request", http.StatusBadRequest)
+		return request, data, 0, 0, false
+	}
+
+	data, err := validateWebAppInitData(request.InitData, s.token, time.Now())
+	if err != nil {
+		slog.Warn("Invalid Web App init data", "error", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return request, data, 0, 0, false
+	}
+
+	chatId, userMessageId, err := moderator.ParseWebAppSessionParam(data.StartParam)
+	if err != nil {
+		slog.Warn("Invalid Web App session", "error", err)
+		http.Error(w, "Unknown verification session", http.StatusNotFound)
+		return request, data, 0, 0, false
+	}
+
+	return request, data, chatId, userMessageId, true
+}
+
+func writeWebAppResponse(w http.ResponseWriter, status int, response map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Error sending response", "error", err)
+	}
+}
+
+func (s *Server) webAppChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	_, data, chatId, userMessageId, ok := s.readWebAppRequest(w, r)
+	if !ok {
+		return
+	}
+
+	text := func(key string) string {
+		return s.mod.UserText(chatId, data.User.LanguageCode, key)
+	}
+
+	question, ok := s.mod.Challenge(chatId, userMessageId, data.User.ID)
+	if !ok {
+		writeWebAppResponse(w, http.StatusNotFound, map[string]string{"status": "expired", "text": text("captcha.expired")})
+		return
+	}
+
+	slog.Info("Web App challenge opened", "chat_id", chatId, "user_id", data.User.ID)
+	writeWebAppResponse(w, http.StatusOK, map[string]string{"status": "pending", "question": question, "submit": text("captcha.submit")})
+}
+
+func (s *Server) webAppVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	request, data, chatId, userMessageId, ok := s.readWebAppRequest(w, r)
+	if !ok {
+		return
+	}
+
+	text := func(key string) string {
+		return s.mod.UserText(chatId, data.User.LanguageCode, key)
+	}
+
+	if _, ok := s.mod.Challenge(chatId, userMessageId, data.User.ID); !ok {
+		writeWebAppResponse(w, http.StatusNotFound, map[string]string{"status": "expired", "text": text("captcha.expired")})
+		return
+	}
+
+	ctx := logging.WithAttrs(r.Context(), "chat_id", chatId, "user_id", data.User.ID)
+	switch s.mod.ResolveVerification(ctx, chatId, userMessageId, data.User.ID, strings.TrimSpace(request.Answer)) {
+	case moderator.VerificationCorrect:
+		writeWebAppResponse(w, http.StatusOK, map[string]string{"status": "verified", "text": text("captcha.correct")})
+	case moderator.VerificationWrong:
+		writeWebAppResponse(w, http.StatusOK, map[string]string{"status": "rejected", "text": text("captcha.wrong")})
+	default:
+		writeWebAppResponse(w, http.StatusForbidden, map[string]string{"status": "forbidden", "text": text("captcha.not_yours")})
+	}
+}
@@ -0,0 +1,125 @@
+// internal/http/webapp_test.go
+
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const webAppTestToken = "123456:TEST-TOKEN"
+
+// signInitData signs the fields like Telegram does for a Mini App.
+func signInitData(token string, values url.Values) string {
+	pairs := make([]string, 0, len(values))
+	for key := range values {
+		pairs = append(pairs, key+"="+values.Get(key))
+	}
+	sort.Strings(pairs)
+
+	secretKey := hmac.New(sha256.New, []byte("WebAppData"))
+	secretKey.Write([]byte(token))
+	hash := hmac.New(sha256.New, secretKey.Sum(nil))
+	hash.Write([]byte(strings.Join(pairs, "\n")))
+
+	signed := url.Values{}
+	for key := range values {
+		signed.Set(key, values.Get(key))
+	}
+	signed.Set("hash", hex.EncodeToString(hash.Sum(nil)))
+
+	return signed.Encode()
+}
+
+func TestValidateWebAppInitData(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	fields := func(authDate time.Time) url.Values {
+		return url.Values{
+			"auth_date":   {strconv.FormatInt(authDate.Unix(), 10)},
+			"query_id":    {"AAHdF6IQAAAAAN0XohDhrOrc"},
+			"start_param": {"-100123_7"},
+			"user":        {`{"id":42,"first_name":"Alice","username":"alice","language_code":"en"}`},
+		}
+	}
+	valid := signInitData(webAppTestToken, fields(now.Add(-time.Minute)))
+
+	tests := []struct {
+		name     string
+		initData string
+		wantErr  string
+	}{
+		{name: "valid", initData: valid},
+		{
+			name:     "tampered user",
+			initData: strings.Replace(valid, "%22id%22%3A42", "%22id%22%3A43", 1),
+			wantErr:  "hash mismatch",
+		},
+		{
+			name:     "tampered start param",
+			initData: strings.Replace(valid, "start_param=-100123_7", "start_param=-100123_8", 1),
+			wantErr:  "hash mismatch",
+		},
+		{
+			name:     "signed with another token",
+			initData: signInitData("654321:OTHER-TOKEN", fields(now.Add(-time.Minute))),
+			wantErr:  "hash mismatch",
+		},
+		{
+			name:     "wrong hash",
+			initData: fields(now.Add(-time.Minute)).Encode() + "&hash=" + strings.Repeat("ab", sha256.Size),
+			wantErr:  "hash mismatch",
+		},
+		{
+			name:     "malformed hash",
+			initData: fields(now.Add(-time.Minute)).Encode() + "&hash=not-hex",
+			wantErr:  "missing or malformed hash",
+		},
+		{
+			name:     "missing hash",
+			initData: fields(now.Add(-time.Minute)).Encode(),
+			wantErr:  "missing or malformed hash",
+		},
+		{
+			name:     "expired auth date",
+			initData: signInitData(webAppTestToken, fields(now.Add(-webAppInitDataMaxAge-time.Second))),
+			wantErr:  "init data is expired",
+		},
+		{
+			name: "missing auth date",
+			initData: signInitData(webAppTestToken, url.Values{
+				"user": {`{"id":42,"first_name":"Alice"}`},
+			}),
+			wantErr: "missing auth_date",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := validateWebAppInitData(tt.initData, webAppTestToken, now)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if data.User.ID != 42 || data.User.FirstName != "Alice" || data.StartParam != "-100123_7" {
+				t.Errorf("data = %+v", data)
+			}
+			if !data.AuthDate.Equal(now.Add(-time.Minute)) {
+				t.Errorf("auth date = %v", data.AuthDate)
+			}
+		})
+	}
+}
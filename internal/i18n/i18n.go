@@ -0,0 +1,88 @@
+// internal/i18n/i18n.go
+
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// DefaultLanguage is used for missing languages and missing keys.
+const DefaultLanguage = "en"
+
+// Catalog holds the user facing texts of every embedded language. Texts are fmt formats.
+type Catalog struct {
+	messages map[string]map[string]string
+}
+
+// Load reads the embedded locales/<language>.json files.
+func Load() (*Catalog, error) {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Catalog{messages: map[string]map[string]string{}}
+	for _, entry := range entries {
+		data, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("locale %s: %v", entry.Name(), err)
+		}
+
+		c.messages[strings.TrimSuffix(entry.Name(), ".json")] = messages
+	}
+
+	if _, ok := c.messages[DefaultLanguage]; !ok {
+		return nil, fmt.Errorf("locale %s is missing", DefaultLanguage)
+	}
+
+	return c, nil
+}
+
+// Normalize turns an IETF language tag like "en-US" into the catalog language "en".
+func Normalize(languageCode string) string {
+	language, _, _ := strings.Cut(strings.ToLower(languageCode), "-")
+	return language
+}
+
+func (c *Catalog) Has(language string) bool {
+	_, ok := c.messages[language]
+	return ok
+}
+
+func (c *Catalog) Languages() []string {
+	languages := make([]string, 0, len(c.messages))
+	for language := range c.messages {
+		languages = append(languages, language)
+	}
+
+	return languages
+}
+
+// T formats the text of key in language, falling back to the default language and then to the key.
+func (c *Catalog) T(language string, key string, args ...interface{}) string {
+	format, ok := c.messages[language][key]
+	if !ok {
+		format, ok = c.messages[DefaultLanguage][key]
+	}
+	if !ok {
+		return key
+	}
+
+	if len(args) == 0 {
+		return format
+	}
+
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,196 @@
+// internal/logging/logging.go
+
+// Package logging sets up the JSON logger of the bot with redaction of secrets
+// and, optionally, of user identifiers and message texts.
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"telegram_moderator/pkg/models"
+)
+
+const redacted = "[REDACTED]"
+
+// attributes holding user identifiers, hashed with HashUsers
+var userKeys = map[string]bool{
+	"user_id":    true,
+	"admin_id":   true,
+	"username":   true,
+	"first_name": true,
+}
+
+// attributes holding message texts, hashed with HashText
+var textKeys = map[string]bool{
+	"text": true,
+	"urls": true,
+}
+
+// UpdateReceived is the message of the debug line holding the raw update.
+const UpdateReceived = "Update received"
+
+// BodyKey is the attribute of a raw update body. It is dropped when users or texts are hashed.
+const BodyKey = "body"
+
+// TraceKey is the attribute of a trace step, which may quote texts and user ids.
+// It is hashed when users or texts are hashed.
+const TraceKey = "trace"
+
+type Options struct {
+	Level slog.Leveler
+	// Secrets are replaced wherever they appear, like the bot token in a request URL
+	Secrets []string
+	// HashUsers replaces user ids and names by a salted hash that still correlates
+	HashUsers bool
+	// HashText replaces message texts and URLs by a salted hash
+	HashText bool
+	Salt     string
+}
+
+// New returns a logger writing JSON lines to w. The lines logged with a context
+// from WithAttrs carry its attributes.
+func New(w io.Writer, opts Options) *slog.Logger {
+	r := redactor{opts: opts}
+
+	return slog.New(contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       opts.Level,
+		ReplaceAttr: r.replace,
+	})})
+}
+
+type attrsKey struct{}
+
+// WithAttrs returns a context whose log lines carry the attributes, like the
+// correlation fields of the update being handled.
+func WithAttrs(ctx context.Context, args ...any) context.Context {
+	record := slog.Record{}
+	record.Add(args...)
+
+	attrs := append([]slog.Attr{}, contextAttrs(ctx)...)
+	record.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	return context.WithValue(ctx, attrsKey{}, attrs)
+}
+
+func contextAttrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// contextHandler adds the attributes of the context a line is logged with,
+// unless the line has its own attribute with the same key.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	attrs := contextAttrs(ctx)
+	if len(attrs) == 0 {
+		return h.Handler.Handle(ctx, record)
+	}
+
+	own := make(map[string]bool, record.NumAttrs())
+	record.Attrs(func(a slog.Attr) bool {
+		own[a.Key] = true
+		return true
+	})
+	for _, a := range attrs {
+		if !own[a.Key] {
+			record.AddAttrs(a)
+		}
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// ParseLevel reads debug, info, warn or error, anything else is info.
+func ParseLevel(value string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return slog.LevelInfo
+	}
+
+	return level
+}
+
+// UpdateAttrs are the correlation fields of an update.
+func UpdateAttrs(update models.Update) []any {
+	attrs := []any{"update_id", update.UpdateID}
+
+	switch {
+	case update.Message != nil:
+		attrs = append(attrs, "chat_id", update.Message.Chat.ID, "user_id", update.Message.From.ID)
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		attrs = append(attrs, "chat_id", update.CallbackQuery.Message.Chat.ID, "user_id", update.CallbackQuery.From.ID)
+	case update.MyChatMember != nil:
+		attrs = append(attrs, "chat_id", update.MyChatMember.Chat.ID, "user_id", update.MyChatMember.From.ID)
+	}
+
+	return attrs
+}
+
+type redactor struct {
+	opts Options
+}
+
+func (r redactor) replace(groups []string, a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+
+	if a.Key == BodyKey && (r.opts.HashUsers || r.opts.HashText) {
+		return slog.String(a.Key, redacted)
+	}
+	if a.Key == TraceKey && (r.opts.HashUsers || r.opts.HashText) {
+		return slog.String(a.Key, r.hash(a.Value))
+	}
+	if userKeys[a.Key] && r.opts.HashUsers {
+		return slog.String(a.Key, r.hash(a.Value))
+	}
+	if textKeys[a.Key] && r.opts.HashText {
+		return slog.String(a.Key, r.hash(a.Value))
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, r.scrub(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, r.scrub(err.Error()))
+		}
+	}
+
+	return a
+}
+
+func (r redactor) scrub(value string) string {
+	for _, secret := range r.opts.Secrets {
+		if secret != "" {
+			value = strings.ReplaceAll(value, secret, redacted)
+		}
+	}
+
+	return value
+}
+
+func (r redactor) hash(value slog.Value) string {
+	sum := sha256.Sum256([]byte(r.opts.Salt + fmt.Sprint(value.Any())))
+	return "sha256:" + hex.EncodeToString(sum[:])[:16]
+}
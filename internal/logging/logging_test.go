@@ -0,0 +1,186 @@
+// internal/logging/logging_test.go
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+const token = "123456:SECRET"
+
+func TestRedaction(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		log     func(logger *slog.Logger)
+		want    map[string]string
+		notWant string
+	}{
+		{
+			name: "token in an error",
+			log: func(logger *slog.Logger) {
+				logger.Error("Error deleting message", "error", errors.New(`Post "https://api.telegram.org/bot`+token+`/deleteMessage": timeout`))
+			},
+			want:    map[string]string{"error": `Post "https://api.telegram.org/bot[REDACTED]/deleteMessage": timeout`},
+			notWant: "SECRET",
+		},
+		{
+			name: "token in the message",
+			log: func(logger *slog.Logger) {
+				logger.Info("calling bot" + token)
+			},
+			want:    map[string]string{"msg": "calling bot[REDACTED]"},
+			notWant: "SECRET",
+		},
+		{
+			name: "plain user fields",
+			log: func(logger *slog.Logger) {
+				logger.Info("Message received", "user_id", 42, "text", "visit example.com")
+			},
+			want: map[string]string{"text": "visit example.com"},
+		},
+		{
+			name: "hashed users and texts",
+			opts: Options{HashUsers: true, HashText: true, Salt: "salt"},
+			log: func(logger *slog.Logger) {
+				logger.With("username", "spammer").Info("Message received", "user_id", 42, "text", "visit example.com", "body", json.RawMessage(`{"text":"visit example.com"}`))
+			},
+			want:    map[string]string{"body": "[REDACTED]"},
+			notWant: "example.com",
+		},
+		{
+			name: "hashed admin id",
+			opts: Options{HashUsers: true, Salt: "salt"},
+			log: func(logger *slog.Logger) {
+				logger.Info("Messages purged", "user_id", 42, "admin_id", 424242)
+			},
+			notWant: "424242",
+		},
+		{
+			name: "hashed trace with hashed users",
+			opts: Options{HashUsers: true, Salt: "salt", Level: slog.LevelDebug},
+			log: func(logger *slog.Logger) {
+				logger.Debug("Trace", TraceKey, "Unverified user 434343, sending the question")
+			},
+			notWant: "434343",
+		},
+		{
+			name: "hashed trace with hashed texts",
+			opts: Options{HashText: true, Salt: "salt", Level: slog.LevelDebug},
+			log: func(logger *slog.Logger) {
+				logger.Debug("Trace", TraceKey, "Received message: visit example.com")
+			},
+			notWant: "example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			tt.opts.Secrets = []string{token}
+			tt.log(New(&out, tt.opts))
+
+			var line map[string]interface{}
+			if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+				t.Fatalf("invalid JSON %q: %v", out.String(), err)
+			}
+			for key, want := range tt.want {
+				if line[key] != want {
+					t.Errorf("%s = %v, want %q", key, line[key], want)
+				}
+			}
+			if tt.notWant != "" && strings.Contains(out.String(), tt.notWant) {
+				t.Errorf("output leaks %q: %s", tt.notWant, out.String())
+			}
+			if tt.opts.HashUsers && (line["user_id"] == "42" || line["username"] == "spammer") {
+				t.Errorf("user fields are not hashed: %s", out.String())
+			}
+		})
+	}
+}
+
+func TestHashCorrelates(t *testing.T) {
+	var out bytes.Buffer
+	logger := New(&out, Options{HashUsers: true, Salt: "salt"})
+	logger.Info("first", "user_id", 42)
+	logger.Info("second", "user_id", 42)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	var first, second map[string]interface{}
+	json.Unmarshal([]byte(lines[0]), &first)
+	json.Unmarshal([]byte(lines[1]), &second)
+	if first["user_id"] != second["user_id"] {
+		t.Errorf("hashes differ: %v and %v", first["user_id"], second["user_id"])
+	}
+}
+
+func TestContextAttrs(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		args []any
+		want map[string]interface{}
+	}{
+		{
+			name: "attributes of the update",
+			ctx:  WithAttrs(context.Background(), "update_id", 7, "chat_id", -100, "user_id", 42),
+			want: map[string]interface{}{"update_id": 7.0, "chat_id": -100.0, "user_id": 42.0},
+		},
+		{
+			name: "own attribute wins",
+			ctx:  WithAttrs(context.Background(), "update_id", 7, "chat_id", -100),
+			args: []any{"chat_id", -200},
+			want: map[string]interface{}{"update_id": 7.0, "chat_id": -200.0},
+		},
+		{
+			name: "nested contexts add up",
+			ctx:  WithAttrs(WithAttrs(context.Background(), "update_id", 7), "job_kind", "action"),
+			want: map[string]interface{}{"update_id": 7.0, "job_kind": "action"},
+		},
+		{
+			name: "plain context",
+			ctx:  context.Background(),
+			want: map[string]interface{}{"update_id": nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			New(&out, Options{}).InfoContext(tt.ctx, "Message deleted", tt.args...)
+
+			if strings.Count(out.String(), `"chat_id"`) > 1 {
+				t.Errorf("duplicate chat_id: %s", out.String())
+			}
+			var line map[string]interface{}
+			if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+				t.Fatalf("invalid JSON %q: %v", out.String(), err)
+			}
+			for key, want := range tt.want {
+				if line[key] != want {
+					t.Errorf("%s = %v, want %v", key, line[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestContextAttrsAreRedacted(t *testing.T) {
+	var out bytes.Buffer
+	ctx := WithAttrs(context.Background(), "update_id", 7, "user_id", 42)
+	New(&out, Options{HashUsers: true, Salt: "salt"}).InfoContext(ctx, "Message deleted")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if hashed, ok := line["user_id"].(string); !ok || !strings.HasPrefix(hashed, "sha256:") {
+		t.Errorf("user_id = %v, want a hash", line["user_id"])
+	}
+}
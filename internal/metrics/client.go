@@ -0,0 +1,38 @@
+// internal/metrics/client.go
+
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"telegram_moderator/internal/telegram"
+	"time"
+)
+
+// Client counts and times the calls of the wrapped Bot API client.
+type Client struct {
+	next telegram.Client
+}
+
+func NewClient(next telegram.Client) *Client {
+	return &Client{next: next}
+}
+
+func (c *Client) Call(method string, params interface{}) (json.RawMessage, error) {
+	start := time.Now()
+	result, err := c.next.Call(method, params)
+	APIDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+
+	code := "200"
+	if err != nil {
+		code = "network"
+		var apiErr *telegram.APIError
+		if errors.As(err, &apiErr) {
+			code = strconv.Itoa(apiErr.Code)
+		}
+	}
+	APICalls.WithLabelValues(method, code).Inc()
+
+	return result, err
+}
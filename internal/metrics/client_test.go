@@ -0,0 +1,45 @@
+// internal/metrics/client_test.go
+
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"telegram_moderator/internal/telegram"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type stubClient struct {
+	err error
+}
+
+func (c stubClient) Call(method string, params interface{}) (json.RawMessage, error) {
+	return json.RawMessage("true"), c.err
+}
+
+func TestClientCountsCodes(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code string
+	}{
+		{name: "success", code: "200"},
+		{name: "api error", err: &telegram.APIError{Method: "deleteMessage", Code: 400}, code: "400"},
+		{name: "network error", err: errors.New("connection refused"), code: "network"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := APICalls.WithLabelValues("deleteMessage", tt.code)
+			before := testutil.ToFloat64(counter)
+
+			NewClient(stubClient{err: tt.err}).Call("deleteMessage", nil)
+
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("counter grew by %v, want 1", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+// internal/metrics/metrics.go
+
+// Package metrics holds the Prometheus metrics of the bot, served at /metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "telegram_moderator"
+
+// captcha results
+const (
+	CaptchaSent    = "sent"
+	CaptchaSolved  = "solved"
+	CaptchaFailed  = "failed"
+	CaptchaExpired = "expired"
+)
+
+var (
+	// Updates counts the webhook updates by type: message, callback_query or other.
+	Updates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "updates_total",
+		Help:      "Updates received by the webhook.",
+	}, []string{"type"})
+
+	// LinksDetected counts the messages with at least one link.
+	LinksDetected = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "links_detected_total",
+		Help:      "Messages containing links.",
+	})
+
+	// Captchas counts the verification questions by result.
+	Captchas = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "captchas_total",
+		Help:      "Verification questions by result: sent, solved, failed or expired.",
+	}, []string{"result"})
+
+	// Deletions counts the messages of users deleted from the chats.
+	Deletions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "deletions_total",
+		Help:      "Messages of users deleted from the chats.",
+	})
+
+	// APICalls counts the Bot API calls by method and error code, "200" on success
+	// and "network" when no response was received.
+	APICalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telegram_api_calls_total",
+		Help:      "Bot API calls by method and error code.",
+	}, []string{"method", "code"})
+
+	// APIDuration is the latency of the Bot API calls by method.
+	APIDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "telegram_api_duration_seconds",
+		Help:      "Latency of the Bot API calls.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	// UpdateDuration is how long the handling of one update takes.
+	UpdateDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "update_duration_seconds",
+		Help:      "Time spent handling one update.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// APICircuitState is the state of the Bot API circuit breaker: 0 closed, 1 half-open, 2 open.
+	APICircuitState = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "telegram_api_circuit_state",
+		Help:      "State of the Bot API circuit breaker: 0 closed, 1 half-open, 2 open.",
+	})
+
+	// APICircuitRejections counts the calls failed fast by the open circuit breaker, by method.
+	APICircuitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telegram_api_circuit_rejections_total",
+		Help:      "Bot API calls rejected by the open circuit breaker.",
+	}, []string{"method"})
+
+	// WebhookPendingUpdates is the pending_update_count of getWebhookInfo.
+	WebhookPendingUpdates = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "webhook_pending_updates",
+		Help:      "Updates Telegram holds for the webhook, not delivered yet.",
+	})
+
+	// WebhookLastErrorDate is the last_error_date of getWebhookInfo, zero without errors.
+	WebhookLastErrorDate = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "webhook_last_error_timestamp_seconds",
+		Help:      "Unix time of the last failed webhook delivery.",
+	})
+
+	// WebhookLastError is 1 for the last_error_message of getWebhookInfo.
+	WebhookLastError = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "webhook_last_error",
+		Help:      "The last webhook delivery error reported by Telegram, as the message label.",
+	}, []string{"message"})
+)
+
+// Source reports the current size of the moderation state.
+type Source interface {
+	PendingSessions() int
+	ScheduledJobs() int
+	QueuedReviews() int
+	DeadLetters() int
+}
+
+// RegisterSource exposes the gauges of the moderation state, call it once.
+func RegisterSource(source Source) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_sessions",
+		Help:      "Verification questions waiting for an answer.",
+	}, func() float64 { return float64(source.PendingSessions()) })
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scheduled_jobs",
+		Help:      "Timeouts, cleanups and expiries waiting in the scheduler.",
+	}, func() float64 { return float64(source.ScheduledJobs()) })
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "review_queue_depth",
+		Help:      "Messages held for review.",
+	}, func() float64 { return float64(source.QueuedReviews()) })
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dead_letters",
+		Help:      "Moderation actions that failed for good.",
+	}, func() float64 { return float64(source.DeadLetters()) })
+}
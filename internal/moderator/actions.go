@@ -0,0 +1,255 @@
+// internal/moderator/actions.go
+
+package moderator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"telegram_moderator/internal/breaker"
+	"telegram_moderator/internal/deadletter"
+	"telegram_moderator/internal/scheduler"
+	"telegram_moderator/internal/telegram"
+	"telegram_moderator/pkg/models"
+	"time"
+)
+
+// maxActionAttempts is how many times a moderation action is tried before it is given up
+const maxActionAttempts = 8
+
+// the first retry waits actionBackoff, every next one twice as long up to maxActionBackoff
+const (
+	actionBackoff    = 5 * time.Second
+	maxActionBackoff = time.Hour
+)
+
+// goneErrors are permanent errors meaning there is nothing left to do
+var goneErrors = []string{
+	"message to delete not found",
+}
+
+// actionPayload is a moderation action waiting for its next attempt.
+type actionPayload struct {
+	Key     string          `json:"key"`
+	Method  string          `json:"method"`
+	ChatID  int64           `json:"chat_id"`
+	Params  json.RawMessage `json:"params"`
+	Attempt int             `json:"attempt"`
+}
+
+func actionJobKey(key string) string {
+	return "action:" + key
+}
+
+// memberActionKey is shared by the restrictions and bans of a user, so a newer one
+// replaces an older one still waiting for a retry.
+func memberActionKey(chatId int64, userId int64) string {
+	return fmt.Sprintf("member:%d:%d", chatId, userId)
+}
+
+// runAction calls the Bot API for a moderation action. A transient failure is retried
+// from the scheduler with exponential backoff, a permanent one goes to the dead letters.
+// The key identifies the action: a success or a new failure replaces the retry queued under it.
+func (m *Moderator) runAction(ctx context.Context, key string, method string, chatId int64, params map[string]interface{}) (json.RawMessage, error) {
+	result, err := m.client.Call(method, params)
+	if err == nil {
+		m.jobs.Cancel(actionJobKey(key))
+		return result, nil
+	}
+
+	raw, marshalErr := json.Marshal(params)
+	if marshalErr != nil {
+		slog.ErrorContext(ctx, "Error encoding moderation action", "chat_id", chatId, "method", method, "error", marshalErr)
+		return nil, err
+	}
+
+	m.actionFailed(ctx, actionPayload{Key: key, Method: method, ChatID: chatId, Params: raw, Attempt: 1}, err)
+	return nil, err
+}
+
+// sendNotice sends a message nobody waits for, like a moderation log entry or an alert.
+// While the circuit breaker of the Bot API is open it goes to the retry queue instead of being lost.
+func (m *Moderator) sendNotice(ctx context.Context, chatId int64, params map[string]interface{}) error {
+	params["chat_id"] = chatId
+
+	_, err := m.client.Call("sendMessage", params)
+	if !errors.Is(err, breaker.ErrOpen) {
+		return err
+	}
+
+	raw, marshalErr := json.Marshal(params)
+	if marshalErr != nil {
+		return err
+	}
+
+	// notices never replace each other
+	key := fmt.Sprintf("notice:%d:%d:%d", chatId, m.clock.Now().UnixNano(), m.noticeSeq.Add(1))
+	m.actionFailed(ctx, actionPayload{Key: key, Method: "sendMessage", ChatID: chatId, Params: raw, Attempt: 1}, err)
+	return nil
+}
+
+func (m *Moderator) actionFailed(ctx context.Context, payload actionPayload, err error) {
+	if unsupported(payload.Method, err) {
+		// the caller falls back to deleteMessage
+		m.jobs.Cancel(actionJobKey(payload.Key))
+		return
+	}
+
+	if isGone(err) {
+		m.jobs.Cancel(actionJobKey(payload.Key))
+		slog.DebugContext(ctx, "Moderation action has nothing left to do", "chat_id", payload.ChatID, "method", payload.Method, "error", err)
+		return
+	}
+
+	if !telegram.Temporary(err) || payload.Attempt >= maxActionAttempts {
+		m.jobs.Cancel(actionJobKey(payload.Key))
+		slog.ErrorContext(ctx, "Moderation action failed for good", "chat_id", payload.ChatID, "method", payload.Method, "attempts", payload.Attempt, "error", err)
+		if addErr := m.deadLetters.Add(deadletter.Entry{
+			Key:      payload.Key,
+			Method:   payload.Method,
+			ChatID:   payload.ChatID,
+			Params:   payload.Params,
+			Attempts: payload.Attempt,
+			Error:    err.Error(),
+			At:       m.clock.Now(),
+		}); addErr != nil {
+			slog.ErrorContext(ctx, "Error saving dead letter", "chat_id", payload.ChatID, "error", addErr)
+		}
+		return
+	}
+
+	delay := actionBackoff << (payload.Attempt - 1)
+	if delay > maxActionBackoff || delay <= 0 {
+		delay = maxActionBackoff
+	}
+	// a 429 the rate limiter gave up on tells how long to wait
+	var apiErr *telegram.APIError
+	if errors.As(err, &apiErr) && apiErr.Parameters.RetryAfter > 0 {
+		if retryAfter := time.Duration(apiErr.Parameters.RetryAfter) * time.Second; retryAfter > delay {
+			delay = retryAfter
+		}
+	}
+
+	slog.WarnContext(ctx, "Moderation action failed, retrying", "chat_id", payload.ChatID, "method", payload.Method, "attempt", payload.Attempt, "retry_in", delay.String(), "error", err)
+	if scheduleErr := m.jobs.Schedule(actionJobKey(payload.Key), jobAction, m.clock.Now().Add(delay), payload); scheduleErr != nil {
+		slog.ErrorContext(ctx, "Error scheduling moderation action retry", "chat_id", payload.ChatID, "error", scheduleErr)
+	}
+}
+
+func isGone(err error) bool {
+	var apiErr *telegram.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	for _, gone := range goneErrors {
+		if strings.Contains(apiErr.Description, gone) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unsupported reports deleteMessages on an older self-hosted Bot API server that doesn't
+// know the method. A 404 of any other method is an ordinary failure.
+func unsupported(method string, err error) bool {
+	var apiErr *telegram.APIError
+	return method == "deleteMessages" && errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// actionJob is the next attempt of a failed moderation action.
+func (m *Moderator) actionJob(job scheduler.Job) {
+	ctx := jobContext(job)
+	var payload actionPayload
+	if err := job.Decode(&payload); err != nil {
+		slog.ErrorContext(ctx, "Error decoding moderation action", "key", job.Key, "error", err)
+		return
+	}
+
+	// numbers stay json.Number, so the chat ids are sent back exactly as they were
+	params := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(payload.Params))
+	decoder.UseNumber()
+	if err := decoder.Decode(&params); err != nil {
+		slog.ErrorContext(ctx, "Error decoding moderation action parameters", "key", job.Key, "error", err)
+		return
+	}
+
+	result, err := m.client.Call(payload.Method, params)
+	if unsupported(payload.Method, err) {
+		// the same fallback as a batch deleted right away
+		m.jobs.Cancel(actionJobKey(payload.Key))
+		m.disableBatchDelete(ctx, err)
+
+		var batch struct {
+			MessageIDs []int64 `json:"message_ids"`
+		}
+		if err := json.Unmarshal(payload.Params, &batch); err != nil {
+			slog.ErrorContext(ctx, "Error decoding message ids of moderation action", "key", job.Key, "error", err)
+			return
+		}
+		m.deleteMessages(ctx, payload.ChatID, batch.MessageIDs)
+		return
+	}
+	if err != nil {
+		payload.Attempt++
+		m.actionFailed(ctx, payload, err)
+		return
+	}
+
+	slog.InfoContext(ctx, "Moderation action succeeded on retry", "chat_id", payload.ChatID, "method", payload.Method, "attempt", payload.Attempt+1)
+
+	// reports sent late are cleaned up like the ones sent right away
+	if strings.HasPrefix(payload.Key, reportActionPrefix) {
+		var sent models.Message
+		if err := json.Unmarshal(result, &sent); err == nil {
+			m.scheduleCleanup(ctx, payload.ChatID, sent.MessageID)
+		}
+	}
+}
+
+// failedCommand lists the moderation actions of the chat that failed for good.
+// "retry" queues them again, "clear" forgets them.
+func (m *Moderator) failedCommand(ctx context.Context, chatId int64, args []string, language string) string {
+	if len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "retry", "clear":
+		default:
+			return m.catalog.T(language, "command.failed_usage")
+		}
+
+		entries, err := m.deadLetters.TakeChat(chatId)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error removing dead letters", "chat_id", chatId, "error", err)
+		}
+		if strings.ToLower(args[0]) == "clear" {
+			return m.catalog.T(language, "command.failed_cleared", len(entries))
+		}
+
+		for _, entry := range entries {
+			payload := actionPayload{Key: entry.Key, Method: entry.Method, ChatID: entry.ChatID, Params: entry.Params}
+			if err := m.jobs.Schedule(actionJobKey(entry.Key), jobAction, m.clock.Now(), payload); err != nil {
+				slog.ErrorContext(ctx, "Error scheduling moderation action retry", "chat_id", chatId, "error", err)
+			}
+		}
+		return m.catalog.T(language, "command.failed_retried", len(entries))
+	}
+
+	entries := m.deadLetters.Chat(chatId)
+	if len(entries) == 0 {
+		return m.catalog.T(language, "command.failed_empty")
+	}
+
+	lines := []string{m.catalog.T(language, "command.failed_header")}
+	for _, entry := range entries {
+		lines = append(lines, fmt.Sprintf("%s %s %s (%d): %s", entry.At.UTC().Format("2006-01-02 15:04"), entry.Method, entry.Key, entry.Attempts, entry.Error))
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,207 @@
+// internal/moderator/appeal.go
+
+package moderator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"telegram_moderator/internal/appeal"
+	"telegram_moderator/internal/config"
+	"telegram_moderator/pkg/models"
+)
+
+const appealCallbackPrefix = "appeal:"
+
+const appealStartPrefix = "appeal_"
+
+const (
+	appealApprove = "approve"
+	appealReject  = "reject"
+)
+
+// appealLink returns the deep link into the private chat with the bot for the case,
+// or an empty string when appeals aren't possible for the chat.
+func (m *Moderator) appealLink(chatId int64, caseId string) string {
+	username := m.username()
+	if username == "" || m.settings.Chat(chatId).LogChatID == 0 {
+		return ""
+	}
+
+	return "https://t.me/" + username + "?start=" + appealStartPrefix + caseId
+}
+
+// handlePrivateMessage runs the appeal conversation. Private chats are never moderated,
+// so it reports true for every private message.
+func (m *Moderator) handlePrivateMessage(ctx context.Context, message *models.Message) bool {
+	if message.Chat.Type != "private" {
+		return false
+	}
+
+	command, args := parseCommand(message.MessageText)
+	language := m.privateLanguage(message.From.LanguageCode)
+
+	switch {
+	case command == "/start" && len(args) > 0 && strings.HasPrefix(args[0], appealStartPrefix):
+		m.startAppeal(ctx, message, strings.TrimPrefix(args[0], appealStartPrefix), language)
+	case command == "/cancel":
+		m.cancelAppeal(ctx, message, language)
+	default:
+		if c, ok := m.appeals.AwaitingReason(message.From.ID); ok {
+			m.submitAppeal(ctx, message, c, language)
+		} else {
+			m.replyPrivate(ctx, message.Chat.ID, m.catalog.T(language, "appeal.help"))
+		}
+	}
+
+	return true
+}
+
+func (m *Moderator) replyPrivate(ctx context.Context, chatId int64, text string) {
+	if _, err := m.sendMessage(ctx, chatId, 0, text); err != nil {
+		slog.ErrorContext(ctx, "Error sending private message", "chat_id", chatId, "error", err)
+	}
+}
+
+func (m *Moderator) startAppeal(ctx context.Context, message *models.Message, caseId string, language string) {
+	c, ok := m.appeals.Get(caseId)
+	if !ok || c.UserID != message.From.ID || !m.appeals.Appealable(c, m.clock.Now()) {
+		m.replyPrivate(ctx, message.Chat.ID, m.catalog.T(language, "appeal.not_appealable"))
+		return
+	}
+
+	// only one appeal can wait for its reason at a time
+	if previous, ok := m.appeals.AwaitingReason(message.From.ID); ok && previous.ID != c.ID {
+		m.appeals.SetStatus(previous.ID, appeal.StatusOpen, "", appeal.StatusAwaitingReason)
+	}
+
+	if _, _, err := m.appeals.SetStatus(c.ID, appeal.StatusAwaitingReason, "", appeal.StatusOpen, appeal.StatusAwaitingReason); err != nil {
+		slog.ErrorContext(ctx, "Error saving appeal case", "error", err)
+	}
+
+	m.replyPrivate(ctx, message.Chat.ID, m.catalog.T(language, "appeal.prompt", c.ChatTitle, c.Text))
+}
+
+func (m *Moderator) cancelAppeal(ctx context.Context, message *models.Message, language string) {
+	c, ok := m.appeals.AwaitingReason(message.From.ID)
+	if !ok {
+		m.replyPrivate(ctx, message.Chat.ID, m.catalog.T(language, "appeal.nothing_to_cancel"))
+		return
+	}
+
+	if _, _, err := m.appeals.SetStatus(c.ID, appeal.StatusOpen, "", appeal.StatusAwaitingReason); err != nil {
+		slog.ErrorContext(ctx, "Error saving appeal case", "error", err)
+	}
+
+	m.replyPrivate(ctx, message.Chat.ID, m.catalog.T(language, "appeal.cancelled"))
+}
+
+func (m *Moderator) submitAppeal(ctx context.Context, message *models.Message, c appeal.Case, language string) {
+	c, ok, err := m.appeals.SetStatus(c.ID, appeal.StatusSubmitted, message.MessageText, appeal.StatusAwaitingReason)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error saving appeal case", "error", err)
+	}
+	if !ok {
+		m.replyPrivate(ctx, message.Chat.ID, m.catalog.T(language, "appeal.not_appealable"))
+		return
+	}
+
+	lines := []string{
+		"#appeal",
+		fmt.Sprintf("Chat: %s (id %d)", c.ChatTitle, c.ChatID),
+		"User: " + describeUser(c.UserID, c.FirstName, c.Username),
+		"Deleted: " + c.DeletedAt.UTC().Format(moderationLogTimeLayout),
+		"Text: " + c.Text,
+		"Appeal: " + c.Reason,
+	}
+
+	_, err = m.client.Call("sendMessage", map[string]interface{}{
+		"chat_id": m.settings.Chat(c.ChatID).LogChatID,
+		"text":    strings.Join(lines, "\n"),
+		"reply_markup": map[string][][]map[string]string{
+			"inline_keyboard": {
+				{
+					{"text": "Approve", "callback_data": appealCallbackPrefix + appealApprove + ":" + c.ID},
+					{"text": "Reject", "callback_data": appealCallbackPrefix + appealReject + ":" + c.ID},
+				},
+			},
+		},
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error posting appeal to the log chat", "chat_id", c.ChatID, "error", err)
+		m.appeals.SetStatus(c.ID, appeal.StatusAwaitingReason, "", appeal.StatusSubmitted)
+		m.replyPrivate(ctx, message.Chat.ID, m.catalog.T(language, "appeal.send_failed"))
+		return
+	}
+
+	m.replyPrivate(ctx, message.Chat.ID, m.catalog.T(language, "appeal.sent"))
+}
+
+func (m *Moderator) handleAppealCallback(ctx context.Context, callbackQuery *models.CallbackQuery) {
+	decision, caseId, found := strings.Cut(strings.TrimPrefix(callbackQuery.Data, appealCallbackPrefix), ":")
+	c, ok := m.appeals.Get(caseId)
+	if !found || !ok {
+		m.answerCallbackQuery(ctx, callbackQuery.ID, m.catalog.T(m.privateLanguage(callbackQuery.From.LanguageCode), "toast.unknown_appeal"))
+		return
+	}
+
+	language := m.userLanguage(c.ChatID, callbackQuery.From.LanguageCode)
+
+	if !m.isChatAdmin(ctx, c.ChatID, callbackQuery.From.ID) {
+		m.answerCallbackQuery(ctx, callbackQuery.ID, m.catalog.T(language, "toast.admins_only"))
+		return
+	}
+
+	status := appeal.StatusRejected
+	if decision == appealApprove {
+		status = appeal.StatusApproved
+	}
+
+	c, ok, err := m.appeals.SetStatus(c.ID, status, "", appeal.StatusSubmitted)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error saving appeal case", "error", err)
+	}
+	if !ok {
+		m.answerCallbackQuery(ctx, callbackQuery.ID, m.catalog.T(language, "toast.already_decided"))
+		return
+	}
+
+	// the author is addressed in the language of the private chat with the bot
+	authorLanguage := m.privateLanguage(c.LanguageCode)
+
+	var note string
+	if status == appeal.StatusApproved {
+		note = m.approveAppeal(ctx, c, language)
+		m.replyPrivate(ctx, c.UserID, m.catalog.T(authorLanguage, "appeal.approved", c.ChatTitle))
+	} else {
+		note = m.catalog.T(language, "toast.rejected")
+		m.replyPrivate(ctx, c.UserID, m.catalog.T(authorLanguage, "appeal.rejected"))
+	}
+
+	m.answerCallbackQuery(ctx, callbackQuery.ID, note)
+	m.markModerationLogEntry(ctx, callbackQuery.Message, m.catalog.T(language, "toast.by", note, describeUser(callbackQuery.From.ID, callbackQuery.From.FirstName, callbackQuery.From.Username)))
+}
+
+// approveAppeal reposts the message attributed to its author and trusts the author from now on.
+func (m *Moderator) approveAppeal(ctx context.Context, c appeal.Case, language string) string {
+	note := m.catalog.T(language, "toast.approved")
+
+	repost := m.catalog.T(m.chatLanguage(c.ChatID), "repost.text", c.FirstName, c.Text)
+	if _, err := m.sendMessage(ctx, c.ChatID, c.PostMessageID, repost); err != nil {
+		slog.ErrorContext(ctx, "Error reposting appealed message", "chat_id", c.ChatID, "error", err)
+		note = m.catalog.T(language, "toast.approved_repost_failed")
+	}
+
+	if err := m.liftPenalty(ctx, c.ChatID, c.UserID, config.PenaltyAction(c.Penalty)); err != nil {
+		slog.ErrorContext(ctx, "Error lifting penalty", "chat_id", c.ChatID, "user_id", c.UserID, "error", err)
+	}
+	if err := m.strikes.Reset(c.ChatID, c.UserID); err != nil {
+		slog.ErrorContext(ctx, "Error resetting strikes", "chat_id", c.ChatID, "user_id", c.UserID, "error", err)
+	}
+	if err := m.verified.Add(c.ChatID, c.UserID, c.Username, c.FirstName, m.clock.Now()); err != nil {
+		slog.ErrorContext(ctx, "Error saving verified user", "chat_id", c.ChatID, "user_id", c.UserID, "error", err)
+	}
+
+	return note
+}
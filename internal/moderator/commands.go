@@ -0,0 +1,149 @@
+// internal/moderator/commands.go
+
+package moderator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"telegram_moderator/pkg/models"
+)
+
+// parseCommand splits "/command@bot_name arg1 arg2" into "/command" and its arguments.
+func parseCommand(text string) (string, []string) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return "", nil
+	}
+
+	command := strings.ToLower(fields[0])
+	if at := strings.Index(command, "@"); at != -1 {
+		command = command[:at]
+	}
+
+	return command, fields[1:]
+}
+
+// handleCommand runs admin commands and reports whether the message was consumed as one.
+// Commands from non-admins fall through to the regular moderation.
+func (m *Moderator) handleCommand(ctx context.Context, message *models.Message) bool {
+	command, args := parseCommand(message.MessageText)
+
+	switch command {
+	case "/verified", "/unverify", "/shadow", "/debug", "/checkperms", "/failed", "/purge":
+	default:
+		return false
+	}
+
+	if !m.isChatAdmin(ctx, message.Chat.ID, message.From.ID) {
+		m.debug(message.Chat.ID, "Command "+command+" from non admin, ignoring.")
+		return false
+	}
+
+	language := m.userLanguage(message.Chat.ID, message.From.LanguageCode)
+
+	var reply string
+	switch command {
+	case "/verified":
+		reply = m.verifiedCommand(message.Chat.ID, language)
+	case "/unverify":
+		reply = m.unverifyCommand(ctx, message, args, language)
+	case "/shadow":
+		reply = m.shadowCommand(message.Chat.ID, args, language)
+	case "/debug":
+		reply = m.debugCommand(ctx, message.Chat.ID, args, language)
+	case "/checkperms":
+		reply = m.checkPermsCommand(ctx, message.Chat.ID, language)
+	case "/failed":
+		reply = m.failedCommand(ctx, message.Chat.ID, args, language)
+	case "/purge":
+		reply = m.purgeCommand(ctx, message, args, language)
+	}
+
+	replyMessageId, err := m.sendMessage(ctx, message.Chat.ID, message.MessageID, reply)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error replying to command", "chat_id", message.Chat.ID, "command", command, "error", err)
+	}
+	m.scheduleCleanup(ctx, message.Chat.ID, replyMessageId)
+
+	return true
+}
+
+func (m *Moderator) verifiedCommand(chatId int64, language string) string {
+	users := m.verified.List(chatId, m.clock.Now())
+	if len(users) == 0 {
+		return m.catalog.T(language, "command.no_verified")
+	}
+
+	lines := []string{m.catalog.T(language, "command.verified_header")}
+	for _, user := range users {
+		line := fmt.Sprintf("%d %s", user.UserID, user.FirstName)
+		if user.Username != "" {
+			line += " @" + user.Username
+		}
+		if !user.ExpiresAt.IsZero() {
+			line += " " + m.catalog.T(language, "command.verified_until", user.ExpiresAt.UTC().Format("2006-01-02 15:04 MST"))
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (m *Moderator) unverifyCommand(ctx context.Context, message *models.Message, args []string, language string) string {
+	userId, ok := commandTargetUserId(message, args)
+	if !ok {
+		return m.catalog.T(language, "command.unverify_usage")
+	}
+
+	revoked, err := m.verified.Revoke(message.Chat.ID, userId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error revoking verification", "chat_id", message.Chat.ID, "user_id", userId, "error", err)
+		return m.catalog.T(language, "command.unverify_failed")
+	}
+
+	if !revoked {
+		return m.catalog.T(language, "command.not_verified", userId)
+	}
+
+	return m.catalog.T(language, "command.unverified", userId)
+}
+
+// commandTargetUserId takes the user id from the first argument or from the replied message.
+func commandTargetUserId(message *models.Message, args []string) (int64, bool) {
+	if len(args) > 0 {
+		userId, err := strconv.ParseInt(args[0], 10, 64)
+		return userId, err == nil
+	}
+
+	if message.ReplyToMessage != nil && message.ReplyToMessage.From.ID != 0 {
+		return message.ReplyToMessage.From.ID, true
+	}
+
+	return 0, false
+}
+
+// debugCommand turns tracing of the chat on or off, without arguments it tells the current state.
+func (m *Moderator) debugCommand(ctx context.Context, chatId int64, args []string, language string) string {
+	if len(args) == 0 {
+		if m.tracer.Enabled(chatId) {
+			return m.catalog.T(language, "command.debug_on")
+		}
+		return m.catalog.T(language, "command.debug_off")
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "on":
+		m.tracer.SetEnabled(chatId, true)
+		slog.InfoContext(ctx, "Tracing turned on", "chat_id", chatId)
+		return m.catalog.T(language, "command.debug_on")
+	case "off":
+		m.tracer.SetEnabled(chatId, false)
+		slog.InfoContext(ctx, "Tracing turned off", "chat_id", chatId)
+		return m.catalog.T(language, "command.debug_off")
+	}
+
+	return m.catalog.T(language, "command.debug_usage")
+}
@@ -0,0 +1,19 @@
+// internal/moderator/health.go
+
+package moderator
+
+import "errors"
+
+// CheckTLDs fails until the TLD list used to detect links is loaded.
+func (m *Moderator) CheckTLDs() error {
+	if len(m.currentTLDs()) == 0 {
+		return errors.New("TLD list is not loaded")
+	}
+
+	return nil
+}
+
+// CheckStorage fails when the data directory can't be written.
+func (m *Moderator) CheckStorage() error {
+	return m.store.CheckWritable()
+}
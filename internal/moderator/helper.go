@@ -0,0 +1,128 @@
+// internal/moderator/helper.go
+
+package moderator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"telegram_moderator/pkg/models"
+	"telegram_moderator/pkg/types"
+	"time"
+)
+
+// debug traces a step of the moderation of the chat, when tracing is on for it.
+func (m *Moderator) debug(chatId int64, text string) {
+	m.tracer.Trace(chatId, text)
+}
+
+func checkIfTrustedSender(status string, firstName string, usernameArg string) bool {
+	for _, role := range types.TrustedRoles {
+		if status == string(role) {
+			return true
+		}
+	}
+
+	for _, name := range types.TrustedNames {
+		if firstName == string(name) {
+			return true
+		}
+	}
+
+	for _, username := range types.TrustedUsernames {
+		if usernameArg == string(username) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *Moderator) isUserGroupMember(ctx context.Context, userId int64, chatId int64, firstName string, username string) bool {
+	status, err := m.getChatMemberStatus(ctx, chatId, userId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting chat member", "chat_id", chatId, "user_id", userId, "error", err)
+		return false
+	}
+
+	return checkIfTrustedSender(status, firstName, username)
+}
+
+func (m *Moderator) getChatMemberStatus(ctx context.Context, chatId int64, userId int64) (string, error) {
+	result, err := m.client.Call("getChatMember", map[string]interface{}{
+		"chat_id": chatId,
+		"user_id": userId,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var member models.ChatMember
+	if err := json.Unmarshal(result, &member); err != nil {
+		return "", err
+	}
+
+	slog.DebugContext(ctx, "Chat member", "chat_id", chatId, "user_id", userId, "status", member.Status)
+
+	return member.Status, nil
+}
+
+func (m *Moderator) isChatAdmin(ctx context.Context, chatId int64, userId int64) bool {
+	status, err := m.getChatMemberStatus(ctx, chatId, userId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting chat member", "chat_id", chatId, "user_id", userId, "error", err)
+		return false
+	}
+
+	return status == string(types.Administrator) || status == string(types.Creator)
+}
+
+func CheckURLsInString(s string, tlds map[string]string) []string {
+	regexPattern := `\b((?:https?://)?[a-zA-Z0-9.-]+\.[a-zA-Z]{2,})\b`
+	re := regexp.MustCompile(regexPattern)
+	matches := re.FindAllString(s, -1)
+
+	validURLs := make([]string, 0)
+	for _, match := range matches {
+		tld := regexp.MustCompile(`\.([a-zA-Z]{2,})$`).FindStringSubmatch(match)
+		if len(tld) > 1 {
+			if _, ok := tlds[tld[1]]; ok {
+				validURLs = append(validURLs, match)
+			}
+		}
+	}
+
+	return validURLs
+}
+
+// tldClient fetches the TLD list, a stuck download must not hold the refresh job forever
+var tldClient = &http.Client{Timeout: 30 * time.Second}
+
+func FetchTLDs(url string) (map[string]string, error) {
+	resp, err := tldClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching TLDs: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlds map[string]string
+	err = json.Unmarshal(body, &tlds)
+	if err != nil {
+		return nil, err
+	}
+
+	return tlds, nil
+}
@@ -0,0 +1,42 @@
+// internal/moderator/i18n.go
+
+package moderator
+
+import (
+	"telegram_moderator/internal/i18n"
+)
+
+// chatLanguage is the language of texts everybody in the chat sees, like reports.
+func (m *Moderator) chatLanguage(chatId int64) string {
+	language := m.settings.Chat(chatId).Language
+	if !m.catalog.Has(language) {
+		return i18n.DefaultLanguage
+	}
+
+	return language
+}
+
+// userLanguage is the language of texts addressed to one user in the chat. The user's own
+// Telegram language is used only when the chat enables use_user_language.
+func (m *Moderator) userLanguage(chatId int64, languageCode string) string {
+	if m.settings.Chat(chatId).UseUserLanguage && m.catalog.Has(i18n.Normalize(languageCode)) {
+		return i18n.Normalize(languageCode)
+	}
+
+	return m.chatLanguage(chatId)
+}
+
+// privateLanguage is the language of the private chat with the user.
+func (m *Moderator) privateLanguage(languageCode string) string {
+	if m.catalog.Has(i18n.Normalize(languageCode)) {
+		return i18n.Normalize(languageCode)
+	}
+
+	return i18n.DefaultLanguage
+}
+
+// UserText is the translation of key in the language of texts addressed to the user in the chat,
+// for the pages outside of Telegram like the verification Web App.
+func (m *Moderator) UserText(chatId int64, languageCode string, key string) string {
+	return m.catalog.T(m.userLanguage(chatId, languageCode), key)
+}
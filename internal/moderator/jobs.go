@@ -0,0 +1,260 @@
+// internal/moderator/jobs.go
+
+package moderator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"telegram_moderator/internal/config"
+	"telegram_moderator/internal/logging"
+	"telegram_moderator/internal/metrics"
+	"telegram_moderator/internal/scheduler"
+	"time"
+)
+
+// kinds of the scheduler jobs
+const (
+	jobVerificationTimeout = "verification_timeout"
+	jobCleanup             = "cleanup"
+	jobReviewExpiry        = "review_expiry"
+	jobPrune               = "prune"
+	jobPermissionAudit     = "permission_audit"
+	jobWebhookCheck        = "webhook_check"
+	jobAction              = "action"
+	jobTLDRefresh          = "tld_refresh"
+)
+
+const verificationTimeout = 30 * time.Second
+
+const pruneInterval = time.Hour
+
+const permissionAuditInterval = 6 * time.Hour
+
+const tldRefreshInterval = 24 * time.Hour
+
+// tldRetryInterval is how soon a failed fetch is retried while no TLD list is loaded
+const tldRetryInterval = time.Minute
+
+const tldRetryKey = "tld_retry"
+
+// verificationTimeoutPayload carries the whole session, so it can be restored after a restart.
+type verificationTimeoutPayload struct {
+	BotQuestionMessageID int64                `json:"bot_question_message_id"`
+	NeededAnswer         int                  `json:"needed_answer"`
+	Question             string               `json:"question"`
+	Pending              *pendingVerification `json:"pending"`
+}
+
+type cleanupPayload struct {
+	ChatID    int64 `json:"chat_id"`
+	MessageID int64 `json:"message_id"`
+}
+
+type reviewExpiryPayload struct {
+	ItemID string `json:"item_id"`
+}
+
+func (m *Moderator) registerJobs() {
+	m.jobs.Handle(jobVerificationTimeout, m.verificationTimeoutJob)
+	m.jobs.Handle(jobCleanup, m.cleanupJob)
+	m.jobs.Handle(jobReviewExpiry, m.reviewExpiryJob)
+	m.jobs.Handle(jobPrune, m.pruneJob)
+	m.jobs.Handle(jobPermissionAudit, m.permissionAuditJob)
+	m.jobs.Handle(jobWebhookCheck, m.webhookCheckJob)
+	m.jobs.Handle(jobAction, m.actionJob)
+	m.jobs.Handle(jobTLDRefresh, m.tldRefreshJob)
+
+	m.jobs.Every("prune", jobPrune, pruneInterval)
+	m.jobs.Every("permission_audit", jobPermissionAudit, permissionAuditInterval)
+	if m.webhookInterval > 0 {
+		m.jobs.Every("webhook_check", jobWebhookCheck, m.webhookInterval)
+	} else {
+		m.jobs.Cancel("webhook_check")
+	}
+	// a list given in the config is never replaced
+	if len(m.currentTLDs()) == 0 {
+		m.jobs.Every("tld_refresh", jobTLDRefresh, tldRefreshInterval)
+	} else {
+		m.jobs.Cancel("tld_refresh")
+		m.jobs.Cancel(tldRetryKey)
+	}
+
+	// items queued before the expiry became a job, scheduling again is harmless
+	for _, item := range m.reviews.All() {
+		if !item.ExpiresAt.IsZero() {
+			m.scheduleReviewExpiry(context.Background(), item.ID, item.ExpiresAt)
+		}
+	}
+}
+
+// jobContext tags the log lines of a job with its kind.
+func jobContext(job scheduler.Job) context.Context {
+	return logging.WithAttrs(context.Background(), "job_kind", job.Kind)
+}
+
+func verificationJobKey(chatId int64, userMessageId int64) string {
+	return fmt.Sprintf("verification:%d:%d", chatId, userMessageId)
+}
+
+// scheduleVerificationTimeout fails the verification when the user doesn't answer in time.
+func (m *Moderator) scheduleVerificationTimeout(ctx context.Context, s *session) {
+	payload := verificationTimeoutPayload{
+		BotQuestionMessageID: s.botQuestionMessageID,
+		NeededAnswer:         s.neededAnswer,
+		Question:             s.question,
+		Pending:              s.pending,
+	}
+
+	key := verificationJobKey(s.pending.ChatID, s.pending.UserMessageID)
+	if err := m.jobs.Schedule(key, jobVerificationTimeout, m.clock.Now().Add(verificationTimeout), payload); err != nil {
+		slog.ErrorContext(ctx, "Error scheduling verification timeout", "chat_id", s.pending.ChatID, "error", err)
+	}
+}
+
+// restoreSessions brings back the sessions that were waiting for an answer before a restart.
+func (m *Moderator) restoreSessions() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, job := range m.jobs.Jobs(jobVerificationTimeout) {
+		var payload verificationTimeoutPayload
+		if err := job.Decode(&payload); err != nil || payload.Pending == nil {
+			slog.Error("Error decoding verification timeout", "key", job.Key, "error", err)
+			continue
+		}
+
+		m.sessions[sessionKey{chatID: payload.Pending.ChatID, messageID: payload.Pending.UserMessageID}] = &session{
+			pending:              payload.Pending,
+			botQuestionMessageID: payload.BotQuestionMessageID,
+			neededAnswer:         payload.NeededAnswer,
+			question:             payload.Question,
+		}
+	}
+}
+
+func (m *Moderator) verificationTimeoutJob(job scheduler.Job) {
+	ctx := jobContext(job)
+	var payload verificationTimeoutPayload
+	if err := job.Decode(&payload); err != nil || payload.Pending == nil {
+		slog.ErrorContext(ctx, "Error decoding verification timeout", "key", job.Key, "error", err)
+		return
+	}
+
+	chatId := payload.Pending.ChatID
+
+	// the session is gone when the user answered at the last moment
+	if _, ok := m.takeSession(chatId, payload.Pending.UserMessageID, payload.Pending.UserID); !ok {
+		return
+	}
+
+	m.debug(chatId, "Timeout reached, deleting messages")
+	metrics.Captchas.WithLabelValues(metrics.CaptchaExpired).Inc()
+
+	m.failVerification(ctx, payload.Pending, reasonVerificationTimeout, payload.BotQuestionMessageID)
+}
+
+// scheduleCleanup deletes a message of the bot after the cleanup TTL of the chat, if it has one.
+func (m *Moderator) scheduleCleanup(ctx context.Context, chatId int64, messageId int64) {
+	ttl := time.Duration(m.settings.Chat(chatId).CleanupTTL)
+	if ttl <= 0 || messageId == 0 || m.shadowed(chatId) {
+		return
+	}
+
+	key := fmt.Sprintf("cleanup:%d:%d", chatId, messageId)
+	if err := m.jobs.Schedule(key, jobCleanup, m.clock.Now().Add(ttl), cleanupPayload{ChatID: chatId, MessageID: messageId}); err != nil {
+		slog.ErrorContext(ctx, "Error scheduling cleanup", "chat_id", chatId, "message_id", messageId, "error", err)
+	}
+}
+
+func (m *Moderator) cleanupJob(job scheduler.Job) {
+	ctx := jobContext(job)
+	var payload cleanupPayload
+	if err := job.Decode(&payload); err != nil {
+		slog.ErrorContext(ctx, "Error decoding cleanup", "key", job.Key, "error", err)
+		return
+	}
+
+	// shadow mode may have been turned on since the cleanup was scheduled
+	if m.shadowed(payload.ChatID) {
+		return
+	}
+
+	m.deleteMessage(ctx, payload.ChatID, payload.MessageID)
+}
+
+func reviewJobKey(itemId string) string {
+	return "review:" + itemId
+}
+
+func (m *Moderator) scheduleReviewExpiry(ctx context.Context, itemId string, expiresAt time.Time) {
+	if err := m.jobs.Schedule(reviewJobKey(itemId), jobReviewExpiry, expiresAt, reviewExpiryPayload{ItemID: itemId}); err != nil {
+		slog.ErrorContext(ctx, "Error scheduling review expiry", "item_id", itemId, "error", err)
+	}
+}
+
+// reviewExpiryJob applies the expiry decision of the chat to an item nobody reviewed in time.
+func (m *Moderator) reviewExpiryJob(job scheduler.Job) {
+	ctx := jobContext(job)
+	var payload reviewExpiryPayload
+	if err := job.Decode(&payload); err != nil {
+		slog.ErrorContext(ctx, "Error decoding review expiry", "key", job.Key, "error", err)
+		return
+	}
+
+	item, ok, err := m.reviews.Take(payload.ItemID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error removing review item", "item_id", payload.ItemID, "error", err)
+	}
+	if !ok {
+		return
+	}
+
+	var decision config.ReviewDecision = m.settings.Chat(item.ChatID).ReviewExpiryAction
+	m.applyReviewDecision(ctx, item, decision, "expiry", m.chatLanguage(item.ChatID))
+}
+
+// pruneJob drops expired entries of the persisted registries.
+func (m *Moderator) pruneJob(job scheduler.Job) {
+	ctx := jobContext(job)
+	now := m.clock.Now()
+
+	if err := m.verified.Prune(now); err != nil {
+		slog.ErrorContext(ctx, "Error pruning verified users", "error", err)
+	}
+	if err := m.appeals.Prune(now); err != nil {
+		slog.ErrorContext(ctx, "Error pruning appeals", "error", err)
+	}
+	if err := m.shadowLog.Prune(now); err != nil {
+		slog.ErrorContext(ctx, "Error pruning shadow log", "error", err)
+	}
+	if err := m.deadLetters.Prune(now); err != nil {
+		slog.ErrorContext(ctx, "Error pruning dead letters", "error", err)
+	}
+	m.recentMessages.Prune(now)
+}
+
+// tldRefreshJob reloads the TLD list. While none is loaded a failed fetch is retried soon.
+func (m *Moderator) tldRefreshJob(job scheduler.Job) {
+	ctx := jobContext(job)
+
+	tlds, err := FetchTLDs(m.tldURL)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error fetching TLDs", "error", err)
+		if len(m.currentTLDs()) == 0 {
+			m.scheduleTLDRetry(m.clock.Now().Add(tldRetryInterval))
+		}
+		return
+	}
+
+	m.tldsMu.Lock()
+	m.tlds = tlds
+	m.tldsMu.Unlock()
+	slog.InfoContext(ctx, "TLD list loaded", "count", len(tlds))
+}
+
+func (m *Moderator) scheduleTLDRetry(at time.Time) {
+	if err := m.jobs.Schedule(tldRetryKey, jobTLDRefresh, at, nil); err != nil {
+		slog.Error("Error scheduling TLD fetch", "error", err)
+	}
+}
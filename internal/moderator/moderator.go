@@ -0,0 +1,541 @@
+// internal/moderator/moderator.go
+
+package moderator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"telegram_moderator/internal/appeal"
+	"telegram_moderator/internal/clock"
+	"telegram_moderator/internal/config"
+	"telegram_moderator/internal/deadletter"
+	"telegram_moderator/internal/i18n"
+	"telegram_moderator/internal/metrics"
+	"telegram_moderator/internal/recent"
+	"telegram_moderator/internal/review"
+	"telegram_moderator/internal/scheduler"
+	"telegram_moderator/internal/shadow"
+	"telegram_moderator/internal/storage"
+	"telegram_moderator/internal/strikes"
+	"telegram_moderator/internal/telegram"
+	"telegram_moderator/internal/tracing"
+	"telegram_moderator/internal/verified"
+	"telegram_moderator/pkg/models"
+	"time"
+)
+
+// DefaultTLDURL is the public TLD list used when Config.TLDURL is empty
+const DefaultTLDURL = "https://raw.githubusercontent.com/umpirsky/tld-list/master/data/en/tld.json"
+
+// Random is the source of the verification questions, *rand.Rand satisfies it.
+type Random interface {
+	Intn(n int) int
+}
+
+// Config holds the dependencies of a Moderator.
+type Config struct {
+	Client   telegram.Client
+	Clock    clock.Clock
+	Random   Random
+	Store    *storage.Store
+	Settings *config.ChatSettingsFile
+	Catalog  *i18n.Catalog
+	// TLDs are the known top level domains, fetched on start and refreshed daily when nil
+	TLDs map[string]string
+	// TLDURL is where the TLD list is fetched from, empty uses the public list
+	TLDURL string
+
+	VerifiedUserTTL time.Duration
+	AppealWindow    time.Duration
+	// BotUsername without @ is used in the appeal deep links, empty takes it from getMe
+	BotUsername string
+	// WebAppDirectLink is the Mini App link of the verification page, empty disables it
+	WebAppDirectLink string
+	// WebhookURL is the URL the webhook must be registered with, empty skips the comparison
+	WebhookURL string
+	// WebhookCheckInterval is how often getWebhookInfo is checked, zero disables the monitor
+	WebhookCheckInterval time.Duration
+	// OwnerChatID receives the alerts about the webhook
+	OwnerChatID int64
+	// DebugChatID is the chat the trace is sent to, it is never audited
+	DebugChatID int64
+	// Tracer receives the step by step trace of the chats with tracing on, nil traces nothing
+	Tracer *tracing.Tracer
+}
+
+// sessionKey identifies the message of a pending verification.
+type sessionKey struct {
+	chatID    int64
+	messageID int64
+}
+
+// session is a verification question waiting for the answer of the message author.
+type session struct {
+	pending              *pendingVerification
+	botQuestionMessageID int64
+	neededAnswer         int
+	question             string
+}
+
+// Moderator checks the messages of non members and runs everything that follows:
+// the verification, penalties, the moderation log, reviews and appeals.
+type Moderator struct {
+	client   telegram.Client
+	clock    clock.Clock
+	random   Random
+	settings *config.ChatSettingsFile
+	catalog  *i18n.Catalog
+	store    *storage.Store
+
+	verified  *verified.Registry
+	strikes   *strikes.Counter
+	reviews   *review.Queue
+	appeals   *appeal.Registry
+	shadowLog *shadow.Log
+	// deadLetters are the moderation actions that failed for good
+	deadLetters *deadletter.List
+	jobs        *scheduler.Scheduler
+	// recentMessages are the latest messages of the users, kept in memory for /purge
+	recentMessages *recent.Messages
+
+	botUsername      string
+	webAppDirectLink string
+	tracer           *tracing.Tracer
+	webhookURL       string
+	webhookInterval  time.Duration
+	ownerChatID      int64
+	debugChatID      int64
+
+	mu       sync.Mutex
+	sessions map[sessionKey]*session
+	// groups where a verification started, audited along with the configured chats
+	seenChats map[int64]bool
+	// missingRights holds the rights last reported as missing per chat
+	missingRights map[int64]string
+
+	tldURL string
+	tldsMu sync.RWMutex
+	tlds   map[string]string
+
+	webhookMu sync.Mutex
+	webhook   webhookState
+
+	// noticeSeq numbers the notices deferred while the circuit breaker is open
+	noticeSeq atomic.Int64
+
+	// noBatchDelete is set once the Bot API server turned out to have no deleteMessages
+	noBatchDelete atomic.Bool
+
+	// me is the bot itself, known once getMe succeeded
+	meMu sync.Mutex
+	me   *models.User
+}
+
+// New loads the persisted moderation state from cfg.Store.
+func New(cfg Config) (*Moderator, error) {
+	m := &Moderator{
+		client:           cfg.Client,
+		clock:            cfg.Clock,
+		random:           cfg.Random,
+		settings:         cfg.Settings,
+		catalog:          cfg.Catalog,
+		store:            cfg.Store,
+		botUsername:      cfg.BotUsername,
+		webAppDirectLink: cfg.WebAppDirectLink,
+		tracer:           cfg.Tracer,
+		webhookURL:       cfg.WebhookURL,
+		webhookInterval:  cfg.WebhookCheckInterval,
+		ownerChatID:      cfg.OwnerChatID,
+		debugChatID:      cfg.DebugChatID,
+		sessions:         map[sessionKey]*session{},
+		seenChats:        map[int64]bool{},
+		missingRights:    map[int64]string{},
+		recentMessages:   recent.NewMessages(recentMessagesPerUser, recentMessagesTotal),
+		tlds:             cfg.TLDs,
+		tldURL:           cfg.TLDURL,
+	}
+	if m.tldURL == "" {
+		m.tldURL = DefaultTLDURL
+	}
+	if m.tracer == nil {
+		m.tracer = tracing.NewTracer()
+	}
+
+	var err error
+	if m.verified, err = verified.NewRegistry(cfg.Store, cfg.VerifiedUserTTL); err != nil {
+		return nil, fmt.Errorf("loading verified users: %v", err)
+	}
+	if m.strikes, err = strikes.NewCounter(cfg.Store); err != nil {
+		return nil, fmt.Errorf("loading strikes: %v", err)
+	}
+	if m.reviews, err = review.NewQueue(cfg.Store); err != nil {
+		return nil, fmt.Errorf("loading review queue: %v", err)
+	}
+	if m.appeals, err = appeal.NewRegistry(cfg.Store, cfg.AppealWindow); err != nil {
+		return nil, fmt.Errorf("loading appeals: %v", err)
+	}
+	if m.shadowLog, err = shadow.NewLog(cfg.Store); err != nil {
+		return nil, fmt.Errorf("loading shadow log: %v", err)
+	}
+	if m.deadLetters, err = deadletter.NewList(cfg.Store); err != nil {
+		return nil, fmt.Errorf("loading dead letters: %v", err)
+	}
+	if m.jobs, err = scheduler.New(cfg.Store, cfg.Clock); err != nil {
+		return nil, fmt.Errorf("loading scheduled jobs: %v", err)
+	}
+
+	m.registerJobs()
+	m.restoreSessions()
+
+	return m, nil
+}
+
+// Start runs the scheduled jobs until stop is closed, the first of them loads the TLD list.
+func (m *Moderator) Start(stop <-chan struct{}) {
+	if err := m.CheckToken(); err != nil {
+		slog.Error("Error validating the bot token", "error", err)
+	}
+	if len(m.currentTLDs()) == 0 {
+		m.scheduleTLDRetry(m.clock.Now())
+	}
+	m.pruneJob(scheduler.Job{Kind: jobPrune})
+	m.permissionAuditJob(scheduler.Job{Kind: jobPermissionAudit})
+	if m.webhookInterval > 0 {
+		m.webhookCheckJob(scheduler.Job{Kind: jobWebhookCheck})
+	}
+
+	go m.jobs.Run(stop)
+}
+
+// RunDueJobs runs the timeouts, cleanups and expiries due at the time of the clock.
+// It lets a fake clock drive the jobs instead of Start.
+func (m *Moderator) RunDueJobs() {
+	m.jobs.RunDue()
+}
+
+// Flush writes the scheduled jobs changed since the last write, before a shutdown.
+func (m *Moderator) Flush() error {
+	return m.jobs.Flush()
+}
+
+func (m *Moderator) currentTLDs() map[string]string {
+	m.tldsMu.RLock()
+	defer m.tldsMu.RUnlock()
+
+	return m.tlds
+}
+
+// PendingSessions is the number of verification questions waiting for an answer.
+func (m *Moderator) PendingSessions() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.sessions)
+}
+
+// ScheduledJobs is the number of jobs waiting in the scheduler.
+func (m *Moderator) ScheduledJobs() int {
+	return m.jobs.Len()
+}
+
+// QueuedReviews is the number of messages held for review.
+func (m *Moderator) QueuedReviews() int {
+	return len(m.reviews.All())
+}
+
+// DeadLetters is the number of moderation actions that failed for good.
+func (m *Moderator) DeadLetters() int {
+	return m.deadLetters.Len()
+}
+
+// HandleUpdate dispatches an update received by the webhook.
+func (m *Moderator) HandleUpdate(ctx context.Context, update models.Update) {
+	start := time.Now()
+	defer func() {
+		metrics.UpdateDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	if update.Message != nil {
+		metrics.Updates.WithLabelValues("message").Inc()
+		m.debug(update.Message.Chat.ID, fmt.Sprintf("Received message: %s", update.Message.Content()))
+		m.rememberMessage(update.Message)
+		m.handleMessage(ctx, update.Message)
+	} else if update.CallbackQuery != nil && update.CallbackQuery.Message != nil {
+		metrics.Updates.WithLabelValues("callback_query").Inc()
+		m.debug(update.CallbackQuery.Message.Chat.ID, fmt.Sprintf("Received callback query: %s", update.CallbackQuery.Data))
+		if strings.HasPrefix(update.CallbackQuery.Data, moderationLogCallbackPrefix) {
+			m.handleModerationLogCallback(ctx, update.CallbackQuery)
+		} else if strings.HasPrefix(update.CallbackQuery.Data, reviewCallbackPrefix) {
+			m.handleReviewCallback(ctx, update.CallbackQuery)
+		} else if strings.HasPrefix(update.CallbackQuery.Data, appealCallbackPrefix) {
+			m.handleAppealCallback(ctx, update.CallbackQuery)
+		} else if update.CallbackQuery.Message.ReplyToMessage != nil {
+			m.handleCallbackQuery(ctx, update.CallbackQuery)
+		}
+	} else if update.MyChatMember != nil {
+		metrics.Updates.WithLabelValues("my_chat_member").Inc()
+		m.handleMyChatMember(ctx, update.MyChatMember)
+	} else {
+		metrics.Updates.WithLabelValues("other").Inc()
+	}
+}
+
+func (m *Moderator) handleMessage(ctx context.Context, message *models.Message) {
+	if message.From.ID != 0 && message.Content() != "" {
+		slog.DebugContext(ctx, "Message received", "chat_id", message.Chat.ID, "user_id", message.From.ID, "text", message.Content())
+
+		if m.handlePrivateMessage(ctx, message) {
+			return
+		}
+		if m.handleCommand(ctx, message) {
+			return
+		}
+
+		// the list is loaded by the TLD refresh job, see /readyz
+		tlds := m.currentTLDs()
+		if len(tlds) == 0 {
+			m.debug(message.Chat.ID, "TLD list is not loaded yet, the message is not checked")
+			return
+		}
+
+		validURLs := CheckURLsInString(message.Content(), tlds)
+		slog.DebugContext(ctx, "Links detected", "chat_id", message.Chat.ID, "user_id", message.From.ID, "urls", strings.Join(validURLs, " "))
+
+		if len(validURLs) > 0 {
+			metrics.LinksDetected.Inc()
+			if m.verified.IsVerified(message.Chat.ID, message.From.ID, m.clock.Now()) {
+				m.debug(message.Chat.ID, "User is already verified, skipping verification.")
+				return
+			}
+
+			isUserGroupMember := m.isUserGroupMember(ctx, message.From.ID, message.Chat.ID, message.From.FirstName, message.From.Username)
+			if !isUserGroupMember {
+				// save the user and the post where user sent message in order to send message in reply to post
+				pending := newPendingVerification(message, validURLs)
+				m.debug(message.Chat.ID, "User is not a group member, user message id is "+strconv.FormatInt(message.MessageID, 10))
+				m.rememberChat(message.Chat.ID)
+				if m.shadowed(message.Chat.ID) {
+					m.shadowVerification(ctx, pending)
+					return
+				}
+				m.sendBotVerificationQuestionMessage(ctx, pending)
+			}
+		}
+	}
+}
+
+func (m *Moderator) handleCallbackQuery(ctx context.Context, callbackQuery *models.CallbackQuery) {
+	chatId := callbackQuery.Message.Chat.ID
+	outcome := m.ResolveVerification(ctx, chatId, callbackQuery.Message.ReplyToMessage.MessageID, callbackQuery.From.ID, callbackQuery.Data)
+
+	language := m.userLanguage(chatId, callbackQuery.From.LanguageCode)
+	switch outcome {
+	case VerificationCorrect:
+		m.answerCallbackQuery(ctx, callbackQuery.ID, m.catalog.T(language, "captcha.correct"))
+	case VerificationWrong:
+		m.answerCallbackQuery(ctx, callbackQuery.ID, m.catalog.T(language, "captcha.wrong"))
+	default:
+		m.answerCallbackQuery(ctx, callbackQuery.ID, m.catalog.T(language, "captcha.not_yours"))
+	}
+}
+
+type VerificationOutcome int
+
+const (
+	VerificationIgnored VerificationOutcome = iota
+	VerificationCorrect
+	VerificationWrong
+)
+
+// takeSession removes the session of the message if fromUserId is its author.
+func (m *Moderator) takeSession(chatId int64, userMessageId int64, fromUserId int64) (*session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := sessionKey{chatID: chatId, messageID: userMessageId}
+	s, ok := m.sessions[key]
+	if !ok || s.pending.UserID != fromUserId {
+		return nil, false
+	}
+
+	delete(m.sessions, key)
+	return s, true
+}
+
+// Challenge returns the verification question of the message if fromUserId is its author.
+func (m *Moderator) Challenge(chatId int64, userMessageId int64, fromUserId int64) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionKey{chatID: chatId, messageID: userMessageId}]
+	if !ok || s.pending.UserID != fromUserId {
+		return "", false
+	}
+
+	return s.question, true
+}
+
+// ResolveVerification completes the pending session of userMessageId with the given answer.
+// It is shared by the inline buttons and the Web App page.
+func (m *Moderator) ResolveVerification(ctx context.Context, chatId int64, userMessageId int64, fromUserId int64, answer string) VerificationOutcome {
+	// only the author of the message can answer, other users clicking the buttons are ignored
+	s, ok := m.takeSession(chatId, userMessageId, fromUserId)
+	if !ok {
+		m.debug(chatId, "No pending verification of the user for the message, ignoring.")
+		return VerificationIgnored
+	}
+	pending := s.pending
+
+	// Cancel the timeout if it is still waiting
+	m.jobs.Cancel(verificationJobKey(chatId, userMessageId))
+
+	m.debug(chatId, fmt.Sprintf("Received answer: %s", answer))
+
+	if answer == strconv.Itoa(s.neededAnswer) {
+		m.debug(chatId, "Correct answer received")
+		m.deleteMessage(ctx, chatId, s.botQuestionMessageID)
+		metrics.Captchas.WithLabelValues(metrics.CaptchaSolved).Inc()
+		if err := m.verified.Add(chatId, pending.UserID, pending.Username, pending.FirstName, m.clock.Now()); err != nil {
+			slog.ErrorContext(ctx, "Error saving verified user", "chat_id", chatId, "user_id", pending.UserID, "error", err)
+		}
+		return VerificationCorrect
+	}
+
+	m.debug(chatId, "Wrong answer received, deleting message.")
+	metrics.Captchas.WithLabelValues(metrics.CaptchaFailed).Inc()
+	m.failVerification(ctx, pending, reasonWrongAnswer, s.botQuestionMessageID)
+
+	return VerificationWrong
+}
+
+func (m *Moderator) sendBotVerificationQuestionMessage(ctx context.Context, pending *pendingVerification) {
+	chatId := pending.ChatID
+	messageId := pending.UserMessageID
+
+	// generate two numbers between 1 and 10
+	num1 := m.random.Intn(10) + 1
+	num2 := m.random.Intn(10) + 1
+	neededSum := num1 + num2
+
+	language := m.userLanguage(chatId, pending.LanguageCode)
+	text := m.catalog.T(language, "captcha.question", num1, num2)
+
+	result, err := m.client.Call("sendMessage", map[string]interface{}{
+		"chat_id":             chatId,
+		"text":                text,
+		"reply_to_message_id": messageId,
+		"reply_markup":        m.generateInlineKeyboardMarkup(neededSum, m.webAppLink(chatId, messageId), m.catalog.T(language, "captcha.webapp_button")),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error sending verification message", "chat_id", chatId, "error", err)
+		m.debug(chatId, "Error sending verification message")
+		return
+	}
+
+	var sent models.Message
+	if err := json.Unmarshal(result, &sent); err != nil {
+		slog.ErrorContext(ctx, "Error parsing verification message response", "chat_id", chatId, "error", err)
+		m.debug(chatId, "Error parsing verification message response")
+		return
+	}
+
+	metrics.Captchas.WithLabelValues(metrics.CaptchaSent).Inc()
+
+	s := &session{
+		pending:              pending,
+		botQuestionMessageID: sent.MessageID,
+		neededAnswer:         neededSum,
+		question:             text,
+	}
+
+	m.mu.Lock()
+	m.sessions[sessionKey{chatID: chatId, messageID: messageId}] = s
+	m.mu.Unlock()
+
+	m.scheduleVerificationTimeout(ctx, s)
+	// the timeout deletes the question, the cleanup is a safety net for a lost timeout
+	m.scheduleCleanup(ctx, chatId, sent.MessageID)
+	m.debug(chatId, fmt.Sprintf("Sent bot verification question message, message id is %d", sent.MessageID))
+}
+
+func (m *Moderator) generateInlineKeyboardMarkup(neededAnswer int, webAppLink string, webAppButtonText string) map[string][][]map[string]string {
+	// generate number in range 0 to 1 (inclusive) in order dynamically put buttons
+	randomNumber := m.random.Intn(2)
+
+	// generate random number in range 1 to 10 (inclusive) in order display spoofed answer
+	spoofedAnswer := m.random.Intn(10) + 1
+	var spoofedAnswerString string = strconv.Itoa(spoofedAnswer)
+
+	var neededAnswerString string = strconv.Itoa(neededAnswer)
+
+	buttons0 := [][]map[string]string{
+		{
+			{"text": neededAnswerString, "callback_data": neededAnswerString},
+			{"text": spoofedAnswerString, "callback_data": spoofedAnswerString},
+		},
+	}
+
+	buttons1 := [][]map[string]string{
+		{
+			{"text": spoofedAnswerString, "callback_data": spoofedAnswerString},
+			{"text": neededAnswerString, "callback_data": neededAnswerString},
+		},
+	}
+
+	var replyMarkup map[string][][]map[string]string
+	if randomNumber == 0 {
+		replyMarkup = map[string][][]map[string]string{"inline_keyboard": buttons0}
+	} else {
+		replyMarkup = map[string][][]map[string]string{"inline_keyboard": buttons1}
+	}
+
+	// open the Web App challenge from a separate row when it is configured
+	if webAppLink != "" {
+		replyMarkup["inline_keyboard"] = append(replyMarkup["inline_keyboard"], []map[string]string{
+			{"text": webAppButtonText, "url": webAppLink},
+		})
+	}
+
+	return replyMarkup
+}
+
+// webAppLink returns the Mini App direct link that opens the verification page
+// for the given pending message, or an empty string when the Web App is not configured.
+func (m *Moderator) webAppLink(chatId int64, userMessageId int64) string {
+	if m.webAppDirectLink == "" {
+		return ""
+	}
+
+	return m.webAppDirectLink + "?startapp=" + WebAppSessionParam(chatId, userMessageId)
+}
+
+// WebAppSessionParam is the start_param of the Mini App for the pending message.
+func WebAppSessionParam(chatId int64, userMessageId int64) string {
+	return strconv.FormatInt(chatId, 10) + "_" + strconv.FormatInt(userMessageId, 10)
+}
+
+// ParseWebAppSessionParam reverses WebAppSessionParam.
+func ParseWebAppSessionParam(param string) (int64, int64, error) {
+	parts := strings.Split(param, "_")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("malformed start_param %q", param)
+	}
+
+	chatId, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	userMessageId, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return chatId, userMessageId, nil
+}
@@ -0,0 +1,1051 @@
+// internal/moderator/moderator_test.go
+
+package moderator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"telegram_moderator/internal/appeal"
+	"telegram_moderator/internal/breaker"
+	"telegram_moderator/internal/clock"
+	"telegram_moderator/internal/config"
+	"telegram_moderator/internal/i18n"
+	"telegram_moderator/internal/metrics"
+	"telegram_moderator/internal/scheduler"
+	"telegram_moderator/internal/storage"
+	"telegram_moderator/internal/telegram"
+	"telegram_moderator/pkg/models"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const (
+	testChatID   = int64(-100123)
+	testAuthorID = int64(42)
+	testOtherID  = int64(43)
+	testBotID    = int64(99)
+)
+
+// fakeClient records the Bot API calls and answers them like Telegram would.
+type fakeClient struct {
+	mu       sync.Mutex
+	calls    []string
+	texts    []string
+	statuses map[int64]string
+	// botMember is what getChatMember reports for the bot itself
+	botMember   models.ChatMember
+	webhookInfo models.WebhookInfo
+	// failures are returned by the next calls of the method, one per call
+	failures map[string][]error
+	// onDelete gets the ids of every deleteMessage and deleteMessages call
+	onDelete      func(ids []int64)
+	nextMessageID int64
+}
+
+func newFakeClient(statuses map[int64]string) *fakeClient {
+	return &fakeClient{statuses: statuses, nextMessageID: 1000}
+}
+
+func (c *fakeClient) Call(method string, params interface{}) (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls = append(c.calls, method)
+
+	if failures := c.failures[method]; len(failures) > 0 {
+		c.failures[method] = failures[1:]
+		return nil, failures[0]
+	}
+
+	switch method {
+	case "deleteMessage", "deleteMessages":
+		if c.onDelete != nil {
+			var p struct {
+				MessageID  int64   `json:"message_id"`
+				MessageIDs []int64 `json:"message_ids"`
+			}
+			raw, _ := json.Marshal(params)
+			json.Unmarshal(raw, &p)
+			if p.MessageID != 0 {
+				p.MessageIDs = append(p.MessageIDs, p.MessageID)
+			}
+			c.onDelete(p.MessageIDs)
+		}
+	case "getWebhookInfo":
+		return json.Marshal(c.webhookInfo)
+	case "getMe":
+		return json.Marshal(models.User{ID: testBotID, IsBot: true, Username: "test_bot"})
+	case "getChatAdministrators":
+		return json.Marshal([]models.ChatMember{
+			{User: models.User{ID: testBotID, IsBot: true}, Status: "administrator"},
+			{User: models.User{ID: testOtherID}, Status: "creator"},
+		})
+	case "getChatMember":
+		var p struct {
+			UserID int64 `json:"user_id"`
+		}
+		raw, _ := json.Marshal(params)
+		json.Unmarshal(raw, &p)
+
+		if p.UserID == testBotID {
+			return json.Marshal(c.botMember)
+		}
+		status, ok := c.statuses[p.UserID]
+		if !ok {
+			status = "left"
+		}
+		return json.Marshal(map[string]string{"status": status})
+	case "sendMessage", "copyMessage":
+		if text, ok := params.(map[string]interface{})["text"].(string); ok {
+			c.texts = append(c.texts, text)
+		}
+		c.nextMessageID++
+		return json.Marshal(map[string]int64{"message_id": c.nextMessageID})
+	}
+
+	return json.RawMessage("true"), nil
+}
+
+func (c *fakeClient) methods() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string{}, c.calls...)
+}
+
+// zeroRandom asks 1 + 1 and shows the right answer first.
+type zeroRandom struct{}
+
+func (zeroRandom) Intn(n int) int {
+	return 0
+}
+
+const (
+	rightAnswer = "2"
+	wrongAnswer = "1"
+)
+
+// sentTexts returns the texts of the sent messages.
+func (c *fakeClient) sentTexts() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string{}, c.texts...)
+}
+
+func newTestModerator(t *testing.T, dir string, client *fakeClient, clk *clock.Fake) *Moderator {
+	t.Helper()
+
+	store, err := storage.NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings, err := config.LoadChatSettings(dir + "/chats.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := i18n.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(Config{
+		Client:          client,
+		Clock:           clk,
+		Random:          zeroRandom{},
+		Store:           store,
+		Settings:        settings,
+		Catalog:         catalog,
+		TLDs:            map[string]string{"com": "Commercial"},
+		VerifiedUserTTL: time.Hour,
+		AppealWindow:    time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func linkMessage(messageId int64, userId int64) models.Update {
+	return models.Update{Message: &models.Message{
+		MessageID:   messageId,
+		From:        models.User{ID: userId, FirstName: "Spammer"},
+		Chat:        models.Chat{ID: testChatID, Type: "supergroup"},
+		MessageText: "visit example.com",
+	}}
+}
+
+func answerClick(userMessageId int64, userId int64, answer string) models.Update {
+	return models.Update{CallbackQuery: &models.CallbackQuery{
+		ID:   "callback",
+		From: models.User{ID: userId},
+		Message: &models.Message{
+			MessageID:      1001,
+			Chat:           models.Chat{ID: testChatID, Type: "supergroup"},
+			ReplyToMessage: &models.ReplyToMessage{MessageID: userMessageId},
+		},
+		Data: answer,
+	}}
+}
+
+func TestVerificationFlows(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses map[int64]string
+		updates  []models.Update
+		// advance moves the clock after the updates and runs the due jobs
+		advance time.Duration
+		// after are the updates handled after the clock moved
+		after        []models.Update
+		wantCalls    []string
+		wantVerified bool
+		wantSession  bool
+	}{
+		{
+			name:      "member link is ignored",
+			statuses:  map[int64]string{testAuthorID: "member"},
+			updates:   []models.Update{linkMessage(1, testAuthorID)},
+			wantCalls: []string{"getChatMember"},
+		},
+		{
+			name:        "non member link is challenged",
+			updates:     []models.Update{linkMessage(1, testAuthorID)},
+			wantCalls:   []string{"getChatMember", "sendMessage"},
+			wantSession: true,
+		},
+		{
+			name:         "correct answer verifies the user",
+			updates:      []models.Update{linkMessage(1, testAuthorID), answerClick(1, testAuthorID, rightAnswer)},
+			wantCalls:    []string{"getChatMember", "sendMessage", "deleteMessage", "answerCallbackQuery"},
+			wantVerified: true,
+		},
+		{
+			name: "verified user is not challenged again",
+			updates: []models.Update{
+				linkMessage(1, testAuthorID),
+				answerClick(1, testAuthorID, rightAnswer),
+				linkMessage(2, testAuthorID),
+			},
+			wantCalls:    []string{"getChatMember", "sendMessage", "deleteMessage", "answerCallbackQuery"},
+			wantVerified: true,
+		},
+		{
+			name:      "wrong answer deletes the message",
+			updates:   []models.Update{linkMessage(1, testAuthorID), answerClick(1, testAuthorID, wrongAnswer)},
+			wantCalls: []string{"getChatMember", "sendMessage", "deleteMessages", "sendMessage", "answerCallbackQuery"},
+		},
+		{
+			name:      "timeout deletes the message",
+			updates:   []models.Update{linkMessage(1, testAuthorID)},
+			advance:   verificationTimeout + time.Second,
+			wantCalls: []string{"getChatMember", "sendMessage", "deleteMessages", "sendMessage"},
+		},
+		{
+			name:        "bystander click is ignored",
+			updates:     []models.Update{linkMessage(1, testAuthorID), answerClick(1, testOtherID, rightAnswer)},
+			wantCalls:   []string{"getChatMember", "sendMessage", "answerCallbackQuery"},
+			wantSession: true,
+		},
+		{
+			name:      "answer after the timeout is ignored",
+			updates:   []models.Update{linkMessage(1, testAuthorID)},
+			advance:   verificationTimeout + time.Second,
+			after:     []models.Update{answerClick(1, testAuthorID, rightAnswer)},
+			wantCalls: []string{"getChatMember", "sendMessage", "deleteMessages", "sendMessage", "answerCallbackQuery"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(tt.statuses)
+			clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+			m := newTestModerator(t, t.TempDir(), client, clk)
+
+			for _, update := range tt.updates {
+				m.HandleUpdate(context.Background(), update)
+			}
+			if tt.advance > 0 {
+				clk.Advance(tt.advance)
+				m.jobs.RunDue()
+			}
+			for _, update := range tt.after {
+				m.HandleUpdate(context.Background(), update)
+			}
+
+			if got := client.methods(); !reflect.DeepEqual(got, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", got, tt.wantCalls)
+			}
+			if got := m.verified.IsVerified(testChatID, testAuthorID, clk.Now()); got != tt.wantVerified {
+				t.Errorf("verified = %v, want %v", got, tt.wantVerified)
+			}
+			if _, got := m.Challenge(testChatID, 1, testAuthorID); got != tt.wantSession {
+				t.Errorf("session = %v, want %v", got, tt.wantSession)
+			}
+		})
+	}
+}
+
+func TestSessionSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	m := newTestModerator(t, dir, newFakeClient(nil), clk)
+	m.HandleUpdate(context.Background(), linkMessage(1, testAuthorID))
+	// a restart loses the changes of the last saveDelay unless they were flushed
+	if err := m.jobs.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newFakeClient(nil)
+	restarted := newTestModerator(t, dir, client, clk)
+	if outcome := restarted.ResolveVerification(context.Background(), testChatID, 1, testAuthorID, rightAnswer); outcome != VerificationCorrect {
+		t.Fatalf("outcome = %v, want %v", outcome, VerificationCorrect)
+	}
+
+	// the answer cancelled the timeout
+	clk.Advance(verificationTimeout + time.Second)
+	restarted.jobs.RunDue()
+
+	if got, want := client.methods(), []string{"deleteMessage"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
+
+func TestShadowMode(t *testing.T) {
+	dir := t.TempDir()
+	settings := `{"-100123": {"shadow": true, "log_chat_id": -200, "cleanup_ttl": "1m", "escalation": [{"action": "delete"}, {"action": "mute", "duration": "1h"}]}}`
+	if err := os.WriteFile(filepath.Join(dir, "chats.json"), []byte(settings), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newFakeClient(map[int64]string{testOtherID: "administrator"})
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	m := newTestModerator(t, dir, client, clk)
+
+	m.HandleUpdate(context.Background(), linkMessage(1, testAuthorID))
+	m.HandleUpdate(context.Background(), linkMessage(2, testAuthorID))
+
+	// only the moderation log hears about the messages
+	want := []string{"getChatMember", "sendMessage", "getChatMember", "sendMessage"}
+	if got := client.methods(); !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+	if texts := client.sentTexts(); len(texts) != 2 || !strings.Contains(texts[1], "mute for 1h0m0s (strike 2)") {
+		t.Errorf("log entries = %q", texts)
+	}
+	if m.strikes.Get(testChatID, testAuthorID, 0, clk.Now()) != 0 {
+		t.Errorf("shadow mode added a strike")
+	}
+
+	m.HandleUpdate(context.Background(), models.Update{Message: &models.Message{
+		MessageID:   3,
+		From:        models.User{ID: testOtherID},
+		Chat:        models.Chat{ID: testChatID, Type: "supergroup"},
+		MessageText: "/shadow",
+	}})
+
+	texts := client.sentTexts()
+	if summary := texts[len(texts)-1]; !strings.Contains(summary, "would have removed 2 message(s) of 1 user(s)") {
+		t.Errorf("summary = %q", summary)
+	}
+
+	// neither the purge nor the cleanup of the replies delete anything
+	var deleted [][]int64
+	client.onDelete = func(ids []int64) { deleted = append(deleted, ids) }
+	m.HandleUpdate(context.Background(), models.Update{Message: &models.Message{
+		MessageID:   4,
+		From:        models.User{ID: testOtherID},
+		Chat:        models.Chat{ID: testChatID, Type: "supergroup"},
+		MessageText: fmt.Sprintf("/purge %d", testAuthorID),
+	}})
+	texts = client.sentTexts()
+	if reply := texts[len(texts)-1]; !strings.Contains(reply, "would remove 2 message(s)") {
+		t.Errorf("purge reply = %q", reply)
+	}
+	clk.Advance(time.Hour)
+	m.jobs.RunDue()
+	if len(deleted) != 0 {
+		t.Errorf("deleted %v in shadow mode", deleted)
+	}
+	if got := m.recentMessages.Since(testChatID, testAuthorID, time.Time{}); len(got) != 2 {
+		t.Errorf("remembered messages = %v, want both kept", got)
+	}
+}
+
+func TestHoldForReview(t *testing.T) {
+	tests := []struct {
+		name string
+		// failures are set after the question is sent
+		failures  map[string][]error
+		wantCalls []string
+		wantItems int
+	}{
+		{
+			name:      "message is deleted after the review is posted",
+			wantCalls: []string{"getChatMember", "sendMessage", "copyMessage", "sendMessage", "deleteMessages", "answerCallbackQuery"},
+			wantItems: 1,
+		},
+		{
+			name:     "failed review post deletes the message with the same copy",
+			failures: map[string][]error{"sendMessage": {&telegram.APIError{Method: "sendMessage", Code: 400, Description: "Bad Request: chat not found"}}},
+			wantCalls: []string{
+				"getChatMember", "sendMessage", "copyMessage", "sendMessage",
+				"deleteMessages", "sendMessage", "sendMessage", "answerCallbackQuery",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			settings := `{"-100123": {"review": true, "log_chat_id": -200, "escalation": [{"action": "delete"}]}}`
+			if err := os.WriteFile(filepath.Join(dir, "chats.json"), []byte(settings), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			client := newFakeClient(nil)
+			clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+			m := newTestModerator(t, dir, client, clk)
+
+			m.HandleUpdate(context.Background(), linkMessage(1, testAuthorID))
+			client.failures = tt.failures
+			m.HandleUpdate(context.Background(), answerClick(1, testAuthorID, wrongAnswer))
+
+			if got := client.methods(); !reflect.DeepEqual(got, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", got, tt.wantCalls)
+			}
+			if got := len(m.reviews.All()); got != tt.wantItems {
+				t.Errorf("review items = %d, want %d", got, tt.wantItems)
+			}
+		})
+	}
+}
+
+func TestTLDRefresh(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"com": "Commercial"}`))
+	}))
+	defer server.Close()
+
+	client := newFakeClient(nil)
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	m := newTestModerator(t, t.TempDir(), client, clk)
+	m.tlds = nil
+	m.tldURL = server.URL
+
+	// without a list the message is not checked and nothing is fetched on its way
+	m.HandleUpdate(context.Background(), linkMessage(1, testAuthorID))
+	if hits.Load() != 0 || len(client.methods()) != 0 {
+		t.Errorf("message fetched the TLDs (%d) or was checked: %v", hits.Load(), client.methods())
+	}
+
+	// the failed fetch is retried a minute later
+	m.tldRefreshJob(scheduler.Job{Kind: jobTLDRefresh})
+	if m.CheckTLDs() == nil {
+		t.Fatal("TLDs loaded from a failed response")
+	}
+	clk.Advance(tldRetryInterval)
+	m.jobs.RunDue()
+	if err := m.CheckTLDs(); err != nil || hits.Load() != 2 {
+		t.Fatalf("after the retry: %v, %d fetches", err, hits.Load())
+	}
+
+	m.HandleUpdate(context.Background(), linkMessage(2, testAuthorID))
+	if want := []string{"getChatMember", "sendMessage"}; !reflect.DeepEqual(client.methods(), want) {
+		t.Errorf("calls = %v, want %v", client.methods(), want)
+	}
+}
+
+func TestPermissionAudit(t *testing.T) {
+	tests := []struct {
+		name      string
+		member    models.ChatMember
+		wantReply string
+		wantAlert string
+	}{
+		{
+			name:      "creator",
+			member:    models.ChatMember{Status: "creator"},
+			wantReply: "The bot has every right it needs.",
+		},
+		{
+			name:      "admin with every right",
+			member:    models.ChatMember{Status: "administrator", CanDeleteMessages: true, CanRestrictMembers: true, CanPinMessages: true},
+			wantReply: "The bot has every right it needs.",
+		},
+		{
+			name:      "admin without delete",
+			member:    models.ChatMember{Status: "administrator", CanRestrictMembers: true, CanPinMessages: true},
+			wantReply: "The bot lacks these admin rights: delete messages.",
+			wantAlert: "lacks admin rights in chat -100123: delete messages.",
+		},
+		{
+			name:      "member",
+			member:    models.ChatMember{Status: "member"},
+			wantReply: "The bot lacks these admin rights: delete messages, ban users, pin messages.",
+			wantAlert: "delete messages, ban users, pin messages",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			settings := `{"-100123": {"log_chat_id": -200}}`
+			if err := os.WriteFile(filepath.Join(dir, "chats.json"), []byte(settings), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			client := newFakeClient(map[int64]string{testOtherID: "administrator"})
+			client.botMember = tt.member
+			m := newTestModerator(t, dir, client, clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+
+			m.HandleUpdate(context.Background(), models.Update{Message: &models.Message{
+				MessageID:   1,
+				From:        models.User{ID: testOtherID},
+				Chat:        models.Chat{ID: testChatID, Type: "supergroup"},
+				MessageText: "/checkperms",
+			}})
+			if texts := client.sentTexts(); len(texts) != 1 || texts[0] != tt.wantReply {
+				t.Fatalf("reply = %q, want %q", texts, tt.wantReply)
+			}
+
+			// the second audit finds the same rights missing and stays quiet
+			m.permissionAuditJob(scheduler.Job{})
+			m.permissionAuditJob(scheduler.Job{})
+
+			alerts := client.sentTexts()[1:]
+			if tt.wantAlert == "" {
+				if len(alerts) != 0 {
+					t.Errorf("alerts = %q, want none", alerts)
+				}
+				return
+			}
+			// the log chat and the human admin
+			if len(alerts) != 2 {
+				t.Fatalf("alerts = %q, want 2", alerts)
+			}
+			for _, alert := range alerts {
+				if !strings.Contains(alert, tt.wantAlert) {
+					t.Errorf("alert = %q, want it to contain %q", alert, tt.wantAlert)
+				}
+			}
+		})
+	}
+}
+
+func TestAuditedChats(t *testing.T) {
+	dir := t.TempDir()
+	settings := `{"default": {"log_chat_id": -201}, "-100123": {"log_chat_id": -200}, "-200": {"language": "en"}, "-300": {}}`
+	if err := os.WriteFile(filepath.Join(dir, "chats.json"), []byte(settings), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newFakeClient(nil)
+	m := newTestModerator(t, dir, client, clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+	m.debugChatID = -300
+
+	message := func(chatId int64, text string) models.Update {
+		return models.Update{Message: &models.Message{
+			MessageID:   1,
+			From:        models.User{ID: testAuthorID},
+			Chat:        models.Chat{ID: chatId, Type: "supergroup"},
+			MessageText: text,
+		}}
+	}
+	// chatter doesn't make a chat moderated, a verification does
+	m.HandleUpdate(context.Background(), message(-100500, "hello"))
+	m.HandleUpdate(context.Background(), message(-100600, "visit example.com"))
+	// neither do the log chats and the debug chat
+	m.HandleUpdate(context.Background(), message(-201, "visit example.com"))
+
+	got := m.auditedChats()
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+	if want := []int64{-100600, -100123}; !reflect.DeepEqual(got, want) {
+		t.Errorf("audited chats = %v, want %v", got, want)
+	}
+}
+
+func TestWebhookMonitor(t *testing.T) {
+	const url = "https://bot.example.com:8443/"
+
+	client := newFakeClient(nil)
+	m := newTestModerator(t, t.TempDir(), client, clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+	m.webhookURL = url
+	m.ownerChatID = -300
+
+	steps := []struct {
+		name      string
+		info      models.WebhookInfo
+		wantAlert string
+	}{
+		{name: "healthy", info: models.WebhookInfo{URL: url}},
+		{name: "delivery error", info: models.WebhookInfo{URL: url, PendingUpdateCount: 7, LastErrorDate: 1704110400, LastErrorMessage: "Connection refused"}, wantAlert: "Connection refused. 7 update(s) are waiting."},
+		{name: "same error again", info: models.WebhookInfo{URL: url, PendingUpdateCount: 9, LastErrorDate: 1704110400, LastErrorMessage: "Connection refused"}},
+		{name: "url changed", info: models.WebhookInfo{URL: "https://other.example.com/", LastErrorDate: 1704110400}, wantAlert: `registered at "https://other.example.com/" instead of "https://bot.example.com:8443/"`},
+		{name: "url still wrong", info: models.WebhookInfo{URL: "https://other.example.com/", LastErrorDate: 1704110400}},
+	}
+
+	for _, step := range steps {
+		before := len(client.sentTexts())
+		client.webhookInfo = step.info
+		m.webhookCheckJob(scheduler.Job{})
+
+		alerts := client.sentTexts()[before:]
+		if step.wantAlert == "" {
+			if len(alerts) != 0 {
+				t.Errorf("%s: alerts = %q, want none", step.name, alerts)
+			}
+			continue
+		}
+		if len(alerts) != 1 || !strings.Contains(alerts[0], step.wantAlert) {
+			t.Errorf("%s: alerts = %q, want one containing %q", step.name, alerts, step.wantAlert)
+		}
+	}
+
+	if got := testutil.ToFloat64(metrics.WebhookPendingUpdates); got != 0 {
+		t.Errorf("pending updates = %v, want 0", got)
+	}
+	if got := testutil.ToFloat64(metrics.WebhookLastErrorDate); got != 1704110400 {
+		t.Errorf("last error date = %v", got)
+	}
+}
+
+func TestActionRetries(t *testing.T) {
+	networkError := errors.New("deleteMessage: connection reset by peer")
+	repeat := func(err error, n int) []error {
+		errs := make([]error, n)
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	tests := []struct {
+		name            string
+		failures        []error
+		wantCalls       int
+		wantDeadLetters int
+	}{
+		{name: "success", wantCalls: 1},
+		{name: "transient error is retried", failures: []error{networkError, &telegram.APIError{Code: 502, Description: "Bad Gateway"}}, wantCalls: 3},
+		{name: "permanent error is a dead letter", failures: []error{&telegram.APIError{Code: 400, Description: "Bad Request: message can't be deleted"}}, wantCalls: 1, wantDeadLetters: 1},
+		{name: "not found is a dead letter", failures: []error{&telegram.APIError{Method: "deleteMessage", Code: 404, Description: "Not Found"}}, wantCalls: 1, wantDeadLetters: 1},
+		{name: "gone message is done", failures: []error{&telegram.APIError{Code: 400, Description: "Bad Request: message to delete not found"}}, wantCalls: 1},
+		{name: "attempts run out", failures: repeat(networkError, maxActionAttempts), wantCalls: maxActionAttempts, wantDeadLetters: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(nil)
+			client.failures = map[string][]error{"deleteMessage": tt.failures}
+			clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+			m := newTestModerator(t, t.TempDir(), client, clk)
+
+			m.deleteMessage(context.Background(), testChatID, 5)
+			for i := 0; i < maxActionAttempts; i++ {
+				clk.Advance(maxActionBackoff)
+				m.RunDueJobs()
+			}
+
+			calls := 0
+			for _, method := range client.methods() {
+				if method == "deleteMessage" {
+					calls++
+				}
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("deleteMessage calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if got := m.DeadLetters(); got != tt.wantDeadLetters {
+				t.Errorf("dead letters = %d, want %d", got, tt.wantDeadLetters)
+			}
+			if jobs := m.jobs.Jobs(jobAction); len(jobs) != 0 {
+				t.Errorf("actions still queued: %v", jobs)
+			}
+		})
+	}
+}
+
+func TestFailedCommand(t *testing.T) {
+	client := newFakeClient(map[int64]string{testOtherID: "administrator"})
+	client.failures = map[string][]error{"banChatMember": {&telegram.APIError{Method: "banChatMember", Code: 400, Description: "Bad Request: not enough rights to restrict/unrestrict chat member"}}}
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	m := newTestModerator(t, t.TempDir(), client, clk)
+
+	if err := m.banChatMember(context.Background(), testChatID, testAuthorID, 0); err == nil {
+		t.Fatal("ban succeeded")
+	}
+
+	command := func(text string) string {
+		before := len(client.sentTexts())
+		m.HandleUpdate(context.Background(), models.Update{Message: &models.Message{
+			MessageID:   1,
+			From:        models.User{ID: testOtherID},
+			Chat:        models.Chat{ID: testChatID, Type: "supergroup"},
+			MessageText: text,
+		}})
+		texts := client.sentTexts()
+		if len(texts) != before+1 {
+			t.Fatalf("%s: replies = %q", text, texts[before:])
+		}
+		return texts[before]
+	}
+
+	if reply := command("/failed"); !strings.Contains(reply, "banChatMember member:-100123:42 (1): banChatMember failed with code 400") {
+		t.Errorf("/failed = %q", reply)
+	}
+	if reply := command("/failed retry"); reply != "1 action(s) queued again." {
+		t.Errorf("/failed retry = %q", reply)
+	}
+
+	m.RunDueJobs()
+	if m.DeadLetters() != 0 {
+		t.Errorf("dead letters = %d after a successful retry", m.DeadLetters())
+	}
+	if reply := command("/failed"); reply != "No failed moderation actions." {
+		t.Errorf("/failed = %q", reply)
+	}
+}
+
+func TestNoticesDeferredWhileOpen(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantErr   bool
+		wantTexts []string
+	}{
+		{name: "sent right away", wantTexts: []string{"entry"}},
+		{name: "deferred while the breaker is open", err: fmt.Errorf("sendMessage: %w", breaker.ErrOpen), wantTexts: []string{"entry"}},
+		{name: "other errors are returned", err: &telegram.APIError{Method: "sendMessage", Code: 403, Description: "Forbidden: bot is not a member of the chat"}, wantErr: true, wantTexts: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(nil)
+			if tt.err != nil {
+				client.failures = map[string][]error{"sendMessage": {tt.err}}
+			}
+			clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+			m := newTestModerator(t, t.TempDir(), client, clk)
+
+			if err := m.sendNotice(context.Background(), -200, map[string]interface{}{"text": "entry"}); (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+			clk.Advance(actionBackoff)
+			m.RunDueJobs()
+
+			if texts := client.sentTexts(); !reflect.DeepEqual(texts, tt.wantTexts) {
+				t.Errorf("sent %q, want %q", texts, tt.wantTexts)
+			}
+			if jobs := m.jobs.Jobs(jobAction); len(jobs) != 0 {
+				t.Errorf("actions still queued: %v", jobs)
+			}
+			// only reports are cleaned up
+			if jobs := m.jobs.Jobs(jobCleanup); len(jobs) != 0 {
+				t.Errorf("cleanups scheduled: %v", jobs)
+			}
+		})
+	}
+}
+
+func TestReportEscapesUserInput(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{
+			name: "default template",
+			want: `Message from <a href="tg://user?id=42">&lt;script&gt;alert(1)&lt;/script&gt; &amp; Co</a> was deleted: the verification question was not answered.`,
+		},
+		{
+			name:     "every field",
+			template: `{{.Mention}}|{{.Name}}|{{.Username}}|{{.ChatTitle}}|{{.URLs}}`,
+			want:     `<a href="tg://user?id=42">&lt;script&gt;alert(1)&lt;/script&gt; &amp; Co</a>|&lt;script&gt;alert(1)&lt;/script&gt; &amp; Co|a&lt;b&gt;|Tom &amp; Jerry &lt;b&gt;fans&lt;/b&gt;|example.com`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			settings, _ := json.Marshal(map[string]interface{}{"default": map[string]string{"report_template": tt.template}})
+			if err := os.WriteFile(filepath.Join(dir, "chats.json"), settings, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			client := newFakeClient(nil)
+			m := newTestModerator(t, dir, client, clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+
+			m.sendDeletionReport(context.Background(), appeal.Case{
+				ID:        "case",
+				ChatID:    testChatID,
+				ChatTitle: "Tom & Jerry <b>fans</b>",
+				UserID:    testAuthorID,
+				Username:  "a<b>",
+				FirstName: "<script>alert(1)</script> & Co",
+				Text:      "<script>steal()</script> visit example.com",
+				URLs:      []string{"example.com"},
+			}, reasonVerificationTimeout, config.PenaltyStep{Action: config.PenaltyDelete})
+
+			texts := client.sentTexts()
+			if len(texts) != 1 {
+				t.Fatalf("sent %q, want one report", texts)
+			}
+			if texts[0] != tt.want {
+				t.Errorf("report = %q, want %q", texts[0], tt.want)
+			}
+			if strings.Contains(texts[0], "<script>") || strings.Contains(texts[0], "steal()") {
+				t.Errorf("report carries raw user input: %q", texts[0])
+			}
+		})
+	}
+}
+
+func TestDeleteMessages(t *testing.T) {
+	ids := func(n int) []int64 {
+		ids := make([]int64, n)
+		for i := range ids {
+			ids[i] = int64(n - i)
+		}
+		return ids
+	}
+	notFound := &telegram.APIError{Method: "deleteMessages", Code: 404, Description: "Not Found"}
+
+	tests := []struct {
+		name       string
+		ids        []int64
+		failures   []error
+		wantBatch  int
+		wantSingle int
+	}{
+		{name: "one message", ids: ids(1), wantSingle: 1},
+		{name: "batch", ids: ids(2), wantBatch: 1},
+		{name: "batches of 100", ids: ids(250), wantBatch: 3},
+		{name: "zero ids are skipped", ids: []int64{0, 7, 0}, wantSingle: 1},
+		{name: "fallback without deleteMessages", ids: ids(3), failures: []error{notFound}, wantBatch: 1, wantSingle: 3},
+		{name: "fallback of a queued batch", ids: ids(3), failures: []error{errors.New("deleteMessages: connection reset by peer"), notFound}, wantBatch: 2, wantSingle: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(nil)
+			client.failures = map[string][]error{"deleteMessages": tt.failures}
+			clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+			m := newTestModerator(t, t.TempDir(), client, clk)
+
+			m.deleteMessages(context.Background(), testChatID, tt.ids)
+			clk.Advance(actionBackoff)
+			m.RunDueJobs()
+
+			batch, single := 0, 0
+			for _, method := range client.methods() {
+				switch method {
+				case "deleteMessages":
+					batch++
+				case "deleteMessage":
+					single++
+				}
+			}
+			if batch != tt.wantBatch || single != tt.wantSingle {
+				t.Errorf("deleteMessages %d, deleteMessage %d, want %d and %d", batch, single, tt.wantBatch, tt.wantSingle)
+			}
+			if m.DeadLetters() != 0 {
+				t.Errorf("dead letters = %d", m.DeadLetters())
+			}
+			if jobs := m.jobs.Jobs(jobAction); len(jobs) != 0 {
+				t.Errorf("actions still queued: %v", jobs)
+			}
+		})
+	}
+}
+
+func TestBanWithHistory(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "chats.json"), []byte(`{"default": {"escalation": [{"action": "ban"}]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newFakeClient(nil)
+	m := newTestModerator(t, dir, client, clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+
+	// messages without links are only remembered
+	for _, id := range []int64{1, 2} {
+		m.HandleUpdate(context.Background(), models.Update{Message: &models.Message{
+			MessageID:   id,
+			From:        models.User{ID: testAuthorID},
+			Chat:        models.Chat{ID: testChatID, Type: "supergroup"},
+			MessageText: "hello",
+		}})
+	}
+
+	var deleted [][]int64
+	client.onDelete = func(ids []int64) { deleted = append(deleted, ids) }
+
+	m.HandleUpdate(context.Background(), linkMessage(3, testAuthorID))
+	m.HandleUpdate(context.Background(), answerClick(3, testAuthorID, wrongAnswer))
+
+	want := [][]int64{{3, 1001}, {1, 2}}
+	if !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deleted = %v, want %v", deleted, want)
+	}
+
+	banned := false
+	for _, method := range client.methods() {
+		banned = banned || method == "banChatMember"
+	}
+	if !banned {
+		t.Errorf("user not banned: %v", client.methods())
+	}
+}
+
+func TestAlbumIsDeletedTogether(t *testing.T) {
+	client := newFakeClient(nil)
+	m := newTestModerator(t, t.TempDir(), client, clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+
+	item := func(id int64, album string, caption string) models.Update {
+		return models.Update{Message: &models.Message{
+			MessageID:    id,
+			From:         models.User{ID: testAuthorID},
+			Chat:         models.Chat{ID: testChatID, Type: "supergroup"},
+			Caption:      caption,
+			MediaGroupID: album,
+		}}
+	}
+	// only the first item of the album has the caption with the link
+	m.HandleUpdate(context.Background(), item(1, "album", "visit example.com"))
+	m.HandleUpdate(context.Background(), item(2, "album", ""))
+	m.HandleUpdate(context.Background(), item(3, "album", ""))
+	m.HandleUpdate(context.Background(), item(4, "other", ""))
+
+	var deleted [][]int64
+	client.onDelete = func(ids []int64) { deleted = append(deleted, ids) }
+	m.HandleUpdate(context.Background(), answerClick(1, testAuthorID, wrongAnswer))
+
+	if want := [][]int64{{1, 2, 3, 1001}}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deleted = %v, want %v", deleted, want)
+	}
+}
+
+func TestModerationLogBan(t *testing.T) {
+	client := newFakeClient(map[int64]string{testOtherID: "administrator"})
+	m := newTestModerator(t, t.TempDir(), client, clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+
+	for _, id := range []int64{1, 2} {
+		m.HandleUpdate(context.Background(), models.Update{Message: &models.Message{
+			MessageID:   id,
+			From:        models.User{ID: testAuthorID},
+			Chat:        models.Chat{ID: testChatID, Type: "supergroup"},
+			MessageText: "hello",
+		}})
+	}
+
+	var deleted [][]int64
+	client.onDelete = func(ids []int64) { deleted = append(deleted, ids) }
+	m.HandleUpdate(context.Background(), models.Update{CallbackQuery: &models.CallbackQuery{
+		ID:      "callback",
+		From:    models.User{ID: testOtherID},
+		Message: &models.Message{MessageID: 7, Chat: models.Chat{ID: -200, Type: "supergroup"}, MessageText: "#deleted"},
+		Data:    fmt.Sprintf("%s%s:%d:%d", moderationLogCallbackPrefix, moderationLogEscalate, testChatID, testAuthorID),
+	}})
+
+	if want := [][]int64{{1, 2}}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deleted = %v, want %v", deleted, want)
+	}
+	if methods := client.methods(); !strings.Contains(strings.Join(methods, " "), "banChatMember") {
+		t.Errorf("user not banned: %v", methods)
+	}
+}
+
+func TestPurgeCommand(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		text       string
+		reply      *models.ReplyToMessage
+		wantReply  string
+		wantDelete []int64
+		wantLog    bool
+	}{
+		{name: "usage", text: "/purge", wantReply: "Usage: /purge [period] in reply to a message of the user, or /purge <user id> [period], for example /purge 123456 2h."},
+		{name: "by user id", text: "/purge 42", wantReply: "Removed 3 message(s) of user 42.", wantDelete: []int64{1, 2, 4}, wantLog: true},
+		{name: "by user id with period", text: "/purge 42 90m", wantReply: "Removed 2 message(s) of user 42.", wantDelete: []int64{2, 4}, wantLog: true},
+		{name: "by reply", text: "/purge 30m", reply: &models.ReplyToMessage{MessageID: 4, From: models.User{ID: testAuthorID}}, wantReply: "Removed 1 message(s) of user 42.", wantDelete: []int64{4}, wantLog: true},
+		{name: "nothing to purge", text: "/purge 7", wantReply: "No recent messages of user 7 to remove."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "chats.json"), []byte(`{"-100123": {"log_chat_id": -200}}`), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			// the author is a member, so the messages are only remembered
+			client := newFakeClient(map[int64]string{testAuthorID: "member", testOtherID: "administrator"})
+			clk := clock.NewFake(start)
+			m := newTestModerator(t, dir, client, clk)
+
+			sent := []struct {
+				id     int64
+				userId int64
+				ago    time.Duration
+			}{
+				{id: 1, userId: testAuthorID, ago: 2 * time.Hour},
+				{id: 2, userId: testAuthorID, ago: time.Hour},
+				{id: 3, userId: testOtherID, ago: time.Hour},
+				{id: 4, userId: testAuthorID, ago: 0},
+			}
+			for _, message := range sent {
+				m.HandleUpdate(context.Background(), models.Update{Message: &models.Message{
+					MessageID:   message.id,
+					From:        models.User{ID: message.userId},
+					Chat:        models.Chat{ID: testChatID, Type: "supergroup"},
+					Date:        start.Add(-message.ago).Unix(),
+					MessageText: "hello",
+				}})
+			}
+
+			var deleted []int64
+			client.onDelete = func(ids []int64) { deleted = append(deleted, ids...) }
+
+			before := len(client.sentTexts())
+			m.HandleUpdate(context.Background(), models.Update{Message: &models.Message{
+				MessageID:      5,
+				From:           models.User{ID: testOtherID, FirstName: "Admin"},
+				Chat:           models.Chat{ID: testChatID, Type: "supergroup"},
+				MessageText:    tt.text,
+				ReplyToMessage: tt.reply,
+			}})
+
+			texts := client.sentTexts()[before:]
+			if len(texts) == 0 || texts[len(texts)-1] != tt.wantReply {
+				t.Fatalf("replies = %q, want %q last", texts, tt.wantReply)
+			}
+			if hasLog := len(texts) == 2 && strings.HasPrefix(texts[0], "#purged"); hasLog != tt.wantLog {
+				t.Errorf("moderation log written = %v, want %v: %q", hasLog, tt.wantLog, texts)
+			}
+			if !reflect.DeepEqual(deleted, tt.wantDelete) {
+				t.Errorf("deleted = %v, want %v", deleted, tt.wantDelete)
+			}
+		})
+	}
+}
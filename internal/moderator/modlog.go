@@ -0,0 +1,201 @@
+// internal/moderator/modlog.go
+
+package moderator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"telegram_moderator/internal/config"
+	"telegram_moderator/pkg/models"
+	"time"
+)
+
+const moderationLogCallbackPrefix = "modlog:"
+
+const (
+	moderationLogUndo     = "undo"
+	moderationLogEscalate = "ban"
+)
+
+const moderationLogTimeLayout = "2006-01-02 15:04:05 MST"
+
+// copyToModerationLog keeps a copy of the message in the log chat before it is deleted
+// and returns the id of the copy, or 0 when the chat has no log chat.
+func (m *Moderator) copyToModerationLog(ctx context.Context, chatId int64, messageId int64) int64 {
+	logChatId := m.settings.Chat(chatId).LogChatID
+	if logChatId == 0 {
+		return 0
+	}
+
+	result, err := m.client.Call("copyMessage", map[string]interface{}{
+		"chat_id":      logChatId,
+		"from_chat_id": chatId,
+		"message_id":   messageId,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error copying message to moderation log", "chat_id", chatId, "message_id", messageId, "error", err)
+		return 0
+	}
+
+	var copied struct {
+		MessageID int64 `json:"message_id"`
+	}
+	if err := json.Unmarshal(result, &copied); err != nil {
+		slog.ErrorContext(ctx, "Error parsing copied message", "chat_id", chatId, "error", err)
+		return 0
+	}
+
+	return copied.MessageID
+}
+
+func describeUser(userId int64, firstName string, username string) string {
+	description := fmt.Sprintf("%s (id %d)", firstName, userId)
+	if username != "" {
+		description = fmt.Sprintf("%s @%s (id %d)", firstName, username, userId)
+	}
+
+	return description
+}
+
+func describePenalty(step config.PenaltyStep) string {
+	if step.Duration > 0 && step.Action != config.PenaltyBan && step.Action != config.PenaltyDelete {
+		return fmt.Sprintf("%s for %s", step.Action, time.Duration(step.Duration))
+	}
+
+	return string(step.Action)
+}
+
+// logModerationAction posts the record of a deleted message to the log chat, in reply to its copy.
+func (m *Moderator) logModerationAction(ctx context.Context, pending *pendingVerification, reason string, strike int, penalty config.PenaltyStep, evidenceMessageId int64) error {
+	logChatId := m.settings.Chat(pending.ChatID).LogChatID
+	if logChatId == 0 {
+		return nil
+	}
+
+	urls := strings.Join(pending.URLs, ", ")
+	if urls == "" {
+		urls = "-"
+	}
+
+	lines := []string{
+		"#deleted " + reason,
+		fmt.Sprintf("Chat: %s (id %d)", pending.ChatTitle, pending.ChatID),
+		"User: " + describeUser(pending.UserID, pending.FirstName, pending.Username),
+		"URLs: " + urls,
+		"Sent: " + pending.Date.UTC().Format(moderationLogTimeLayout),
+		"Deleted: " + m.clock.Now().UTC().Format(moderationLogTimeLayout),
+		fmt.Sprintf("Penalty: %s (strike %d)", describePenalty(penalty), strike),
+	}
+	if evidenceMessageId == 0 {
+		lines = append(lines, "Text: "+pending.Text)
+	}
+
+	params := map[string]interface{}{
+		"text":         strings.Join(lines, "\n"),
+		"reply_markup": moderationLogKeyboard(pending.ChatID, pending.UserID, penalty.Action),
+	}
+	if evidenceMessageId != 0 {
+		params["reply_to_message_id"] = evidenceMessageId
+	}
+
+	return m.sendNotice(ctx, logChatId, params)
+}
+
+func moderationLogKeyboard(chatId int64, userId int64, action config.PenaltyAction) map[string][][]map[string]string {
+	target := fmt.Sprintf("%d:%d", chatId, userId)
+
+	row := []map[string]string{
+		{"text": "Undo", "callback_data": moderationLogCallbackPrefix + moderationLogUndo + ":" + target + ":" + string(action)},
+	}
+	if action != config.PenaltyBan {
+		row = append(row, map[string]string{"text": "Ban", "callback_data": moderationLogCallbackPrefix + moderationLogEscalate + ":" + target})
+	}
+
+	return map[string][][]map[string]string{"inline_keyboard": {row}}
+}
+
+// handleModerationLogCallback runs the undo and escalate buttons of the log chat.
+// Only admins of the moderated chat may use them.
+func (m *Moderator) handleModerationLogCallback(ctx context.Context, callbackQuery *models.CallbackQuery) {
+	language := m.privateLanguage(callbackQuery.From.LanguageCode)
+
+	parts := strings.Split(strings.TrimPrefix(callbackQuery.Data, moderationLogCallbackPrefix), ":")
+	if len(parts) < 3 {
+		m.answerCallbackQuery(ctx, callbackQuery.ID, m.catalog.T(language, "toast.unknown_action"))
+		return
+	}
+
+	chatId, errChat := strconv.ParseInt(parts[1], 10, 64)
+	userId, errUser := strconv.ParseInt(parts[2], 10, 64)
+	if errChat != nil || errUser != nil {
+		m.answerCallbackQuery(ctx, callbackQuery.ID, m.catalog.T(language, "toast.unknown_action"))
+		return
+	}
+
+	language = m.userLanguage(chatId, callbackQuery.From.LanguageCode)
+
+	if !m.isChatAdmin(ctx, chatId, callbackQuery.From.ID) {
+		m.answerCallbackQuery(ctx, callbackQuery.ID, m.catalog.T(language, "toast.admins_only"))
+		return
+	}
+
+	var err error
+	var status string
+	switch parts[0] {
+	case moderationLogUndo:
+		var action config.PenaltyAction
+		if len(parts) > 3 {
+			action = config.PenaltyAction(parts[3])
+		}
+		err = m.liftPenalty(ctx, chatId, userId, action)
+		if err == nil {
+			err = m.strikes.Reset(chatId, userId)
+		}
+		status = m.catalog.T(language, "toast.undone")
+	case moderationLogEscalate:
+		err = m.banWithHistory(ctx, chatId, userId)
+		status = m.catalog.T(language, "toast.banned")
+	default:
+		m.answerCallbackQuery(ctx, callbackQuery.ID, m.catalog.T(language, "toast.unknown_action"))
+		return
+	}
+
+	if err != nil {
+		slog.ErrorContext(ctx, "Error running moderation log action", "action", parts[0], "error", err)
+		m.answerCallbackQuery(ctx, callbackQuery.ID, m.catalog.T(language, "toast.failed", err.Error()))
+		return
+	}
+
+	m.answerCallbackQuery(ctx, callbackQuery.ID, status)
+	m.markModerationLogEntry(ctx, callbackQuery.Message, m.catalog.T(language, "toast.by", status, describeUser(callbackQuery.From.ID, callbackQuery.From.FirstName, callbackQuery.From.Username)))
+}
+
+// markModerationLogEntry appends the outcome to a log entry and removes its buttons.
+func (m *Moderator) markModerationLogEntry(ctx context.Context, message *models.Message, note string) {
+	if message == nil {
+		return
+	}
+
+	_, err := m.client.Call("editMessageText", map[string]interface{}{
+		"chat_id":    message.Chat.ID,
+		"message_id": message.MessageID,
+		"text":       message.MessageText + "\n\n" + note,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error editing moderation log entry", "error", err)
+	}
+}
+
+func (m *Moderator) answerCallbackQuery(ctx context.Context, callbackQueryId string, text string) {
+	_, err := m.client.Call("answerCallbackQuery", map[string]interface{}{
+		"callback_query_id": callbackQueryId,
+		"text":              text,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error answering callback query", "error", err)
+	}
+}
@@ -0,0 +1,154 @@
+// internal/moderator/penalties.go
+
+package moderator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"telegram_moderator/internal/config"
+	"time"
+)
+
+// Telegram treats restrictions and bans shorter than 30 seconds as permanent
+const minimalRestrictionDuration = 30 * time.Second
+
+// punishFailedVerification adds a strike to the user and applies the escalation step of the chat.
+// The message itself is already deleted by the caller.
+func (m *Moderator) punishFailedVerification(ctx context.Context, chatId int64, userId int64) (int, config.PenaltyStep) {
+	if userId == 0 {
+		return 0, config.PenaltyStep{Action: config.PenaltyDelete}
+	}
+
+	settings := m.settings.Chat(chatId)
+	now := m.clock.Now()
+
+	strike, err := m.strikes.Add(chatId, userId, time.Duration(settings.StrikeDecay), now)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error saving strike", "chat_id", chatId, "user_id", userId, "error", err)
+	}
+
+	step := settings.Penalty(strike)
+	m.debug(chatId, fmt.Sprintf("User %d has %d strike(s), applying %s", userId, strike, step.Action))
+
+	if err := m.applyPenalty(ctx, chatId, userId, step, now); err != nil {
+		slog.ErrorContext(ctx, "Error applying penalty", "chat_id", chatId, "user_id", userId, "action", string(step.Action), "error", err)
+	}
+
+	return strike, step
+}
+
+func (m *Moderator) applyPenalty(ctx context.Context, chatId int64, userId int64, step config.PenaltyStep, now time.Time) error {
+	duration := time.Duration(step.Duration)
+
+	switch step.Action {
+	case config.PenaltyMute:
+		return m.restrictChatMember(ctx, chatId, userId, untilDate(now, duration))
+	case config.PenaltyKick:
+		// a kick is a ban that expires, so it must not fall into the permanent range
+		if duration < time.Minute {
+			duration = time.Minute
+		}
+		return m.banChatMember(ctx, chatId, userId, untilDate(now, duration))
+	case config.PenaltyBan:
+		return m.banWithHistory(ctx, chatId, userId)
+	}
+
+	return nil
+}
+
+// untilDate converts a duration into the until_date parameter, zero means forever.
+func untilDate(now time.Time, duration time.Duration) int64 {
+	if duration <= 0 {
+		return 0
+	}
+
+	if duration < minimalRestrictionDuration {
+		duration = minimalRestrictionDuration
+	}
+
+	return now.Add(duration).Unix()
+}
+
+func (m *Moderator) restrictChatMember(ctx context.Context, chatId int64, userId int64, until int64) error {
+	_, err := m.runAction(ctx, memberActionKey(chatId, userId), "restrictChatMember", chatId, map[string]interface{}{
+		"chat_id": chatId,
+		"user_id": userId,
+		"permissions": map[string]bool{
+			"can_send_messages":         false,
+			"can_send_audios":           false,
+			"can_send_documents":        false,
+			"can_send_photos":           false,
+			"can_send_videos":           false,
+			"can_send_video_notes":      false,
+			"can_send_voice_notes":      false,
+			"can_send_polls":            false,
+			"can_send_other_messages":   false,
+			"can_add_web_page_previews": false,
+		},
+		"until_date": until,
+	})
+
+	return err
+}
+
+// liftPenalty reverts a mute or a ban, the user is not added back to the chat.
+func (m *Moderator) liftPenalty(ctx context.Context, chatId int64, userId int64, action config.PenaltyAction) error {
+	switch action {
+	case config.PenaltyMute:
+		return m.restoreChatMember(ctx, chatId, userId)
+	case config.PenaltyKick, config.PenaltyBan:
+		_, err := m.runAction(ctx, memberActionKey(chatId, userId), "unbanChatMember", chatId, map[string]interface{}{
+			"chat_id":        chatId,
+			"user_id":        userId,
+			"only_if_banned": true,
+		})
+		return err
+	}
+
+	return nil
+}
+
+func (m *Moderator) restoreChatMember(ctx context.Context, chatId int64, userId int64) error {
+	_, err := m.runAction(ctx, memberActionKey(chatId, userId), "restrictChatMember", chatId, map[string]interface{}{
+		"chat_id": chatId,
+		"user_id": userId,
+		"permissions": map[string]bool{
+			"can_send_messages":         true,
+			"can_send_audios":           true,
+			"can_send_documents":        true,
+			"can_send_photos":           true,
+			"can_send_videos":           true,
+			"can_send_video_notes":      true,
+			"can_send_voice_notes":      true,
+			"can_send_polls":            true,
+			"can_send_other_messages":   true,
+			"can_add_web_page_previews": true,
+		},
+	})
+
+	return err
+}
+
+func (m *Moderator) banChatMember(ctx context.Context, chatId int64, userId int64, until int64) error {
+	_, err := m.runAction(ctx, memberActionKey(chatId, userId), "banChatMember", chatId, map[string]interface{}{
+		"chat_id":    chatId,
+		"user_id":    userId,
+		"until_date": until,
+	})
+
+	return err
+}
+
+// banWithHistory bans the user for good and deletes their remembered messages in batches,
+// also when the ban itself is waiting for a retry.
+func (m *Moderator) banWithHistory(ctx context.Context, chatId int64, userId int64) error {
+	err := m.banChatMember(ctx, chatId, userId, 0)
+
+	if history := m.recentMessages.Take(chatId, userId, time.Time{}); len(history) > 0 {
+		m.debug(chatId, fmt.Sprintf("Deleting %d remembered message(s) of banned user %d", len(history), userId))
+		m.deleteMessages(ctx, chatId, history)
+	}
+
+	return err
+}
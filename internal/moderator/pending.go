@@ -0,0 +1,125 @@
+// internal/moderator/pending.go
+
+package moderator
+
+import (
+	"context"
+	"log/slog"
+	"telegram_moderator/internal/appeal"
+	"telegram_moderator/internal/config"
+	"telegram_moderator/internal/metrics"
+	"telegram_moderator/pkg/models"
+	"time"
+)
+
+// reasons of a failed verification, translated with the "reason." prefix
+const (
+	reasonWrongAnswer         = "wrong_answer"
+	reasonVerificationTimeout = "verification_timeout"
+)
+
+// pendingVerification is the message of a non member waiting for the answer to the bot question.
+type pendingVerification struct {
+	ChatID        int64  `json:"chat_id"`
+	ChatTitle     string `json:"chat_title"`
+	UserMessageID int64  `json:"user_message_id"`
+	UserID        int64  `json:"user_id"`
+	Username      string `json:"username"`
+	FirstName     string `json:"first_name"`
+	LanguageCode  string `json:"language_code"`
+	// PostMessageID is the channel post the comment was sent to
+	PostMessageID int64 `json:"post_message_id"`
+	// MediaGroupID is the album the message belongs to, its other items go with it
+	MediaGroupID string    `json:"media_group_id,omitempty"`
+	Text         string    `json:"text"`
+	URLs         []string  `json:"urls"`
+	Date         time.Time `json:"date"`
+}
+
+func newPendingVerification(message *models.Message, urls []string) *pendingVerification {
+	pending := &pendingVerification{
+		ChatID:        message.Chat.ID,
+		ChatTitle:     message.Chat.Title,
+		UserMessageID: message.MessageID,
+		UserID:        message.From.ID,
+		Username:      message.From.Username,
+		FirstName:     message.From.FirstName,
+		LanguageCode:  message.From.LanguageCode,
+		MediaGroupID:  message.MediaGroupID,
+		Text:          message.Content(),
+		URLs:          urls,
+		Date:          time.Unix(message.Date, 0),
+	}
+
+	if message.ReplyToMessage != nil {
+		pending.PostMessageID = message.ReplyToMessage.MessageID
+	}
+
+	return pending
+}
+
+// failVerification handles a wrong or missing answer. The message is either held for review
+// or deleted right away with a report in reply to the post. The verification question
+// is deleted along with the message.
+func (m *Moderator) failVerification(ctx context.Context, pending *pendingVerification, reason string, questionMessageId int64) {
+	settings := m.settings.Chat(pending.ChatID)
+	evidenceMessageId := m.copyToModerationLog(ctx, pending.ChatID, pending.UserMessageID)
+	if settings.Review && settings.LogChatID != 0 {
+		err := m.holdForReview(ctx, pending, reason, evidenceMessageId, questionMessageId)
+		if err == nil {
+			return
+		}
+		slog.ErrorContext(ctx, "Error holding message for review, deleting it instead", "chat_id", pending.ChatID, "user_id", pending.UserID, "error", err)
+	}
+
+	penalty := m.rejectMessage(ctx, pending, reason, evidenceMessageId, questionMessageId)
+
+	// send report message in reply to post that message was sent by non group member, user id, username and first name
+	m.debug(pending.ChatID, "After deleting their message, sending message in reply to post with report text.")
+	m.sendDeletionReport(ctx, pending.appealCase(m.clock.Now()), reason, penalty)
+}
+
+func (p *pendingVerification) appealCase(deletedAt time.Time) appeal.Case {
+	return appeal.Case{
+		ID:            appeal.CaseID(p.ChatID, p.UserMessageID),
+		ChatID:        p.ChatID,
+		ChatTitle:     p.ChatTitle,
+		UserID:        p.UserID,
+		Username:      p.Username,
+		FirstName:     p.FirstName,
+		LanguageCode:  p.LanguageCode,
+		PostMessageID: p.PostMessageID,
+		Text:          p.Text,
+		URLs:          p.URLs,
+		DeletedAt:     deletedAt,
+	}
+}
+
+// rejectMessage deletes the message of a user who failed the verification, together with
+// the bot messages about it, punishes the user and records the action in the moderation log of the chat,
+// in reply to the copy of the message kept there.
+func (m *Moderator) rejectMessage(ctx context.Context, pending *pendingVerification, reason string, evidenceMessageId int64, botMessageIds ...int64) config.PenaltyStep {
+	userMessageIds := m.userMessageIds(pending)
+	m.deleteMessages(ctx, pending.ChatID, append(userMessageIds, botMessageIds...))
+	m.recentMessages.Forget(pending.ChatID, pending.UserID, userMessageIds...)
+	metrics.Deletions.Inc()
+	strike, penalty := m.punishFailedVerification(ctx, pending.ChatID, pending.UserID)
+
+	if err := m.logModerationAction(ctx, pending, reason, strike, penalty, evidenceMessageId); err != nil {
+		slog.ErrorContext(ctx, "Error writing moderation log", "chat_id", pending.ChatID, "error", err)
+	}
+
+	return penalty
+}
+
+// userMessageIds are the message of the user and the other items of its album.
+func (m *Moderator) userMessageIds(pending *pendingVerification) []int64 {
+	ids := []int64{pending.UserMessageID}
+	for _, id := range m.recentMessages.Album(pending.ChatID, pending.UserID, pending.MediaGroupID) {
+		if id != pending.UserMessageID {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
@@ -0,0 +1,215 @@
+// internal/moderator/permissions.go
+
+package moderator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"telegram_moderator/internal/scheduler"
+	"telegram_moderator/pkg/models"
+	"telegram_moderator/pkg/types"
+)
+
+// botRight is an administrator right the moderation depends on.
+type botRight struct {
+	// name is the suffix of the translation key of the right
+	name    string
+	granted func(member models.ChatMember) bool
+}
+
+var requiredRights = []botRight{
+	{name: "delete", granted: func(member models.ChatMember) bool { return member.CanDeleteMessages }},
+	{name: "restrict", granted: func(member models.ChatMember) bool { return member.CanRestrictMembers }},
+	{name: "pin", granted: func(member models.ChatMember) bool { return member.CanPinMessages }},
+}
+
+// rememberChat adds a group where a verification started to the permission audit.
+func (m *Moderator) rememberChat(chatId int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.seenChats[chatId] = true
+}
+
+// auditedChats are the moderated chats: the configured ones and the groups where a
+// verification started since the start. The log chats and the debug chat are left out.
+func (m *Moderator) auditedChats() []int64 {
+	excluded := map[int64]bool{m.debugChatID: true}
+	for _, chatId := range m.settings.LogChatIDs() {
+		excluded[chatId] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chats := []int64{}
+	for _, chatId := range m.settings.ChatIDs() {
+		if !excluded[chatId] {
+			excluded[chatId] = true
+			chats = append(chats, chatId)
+		}
+	}
+	for chatId := range m.seenChats {
+		if !excluded[chatId] {
+			chats = append(chats, chatId)
+		}
+	}
+
+	return chats
+}
+
+// moderated tells whether the chat is one of the audited chats.
+func (m *Moderator) moderated(chatId int64) bool {
+	for _, audited := range m.auditedChats() {
+		if audited == chatId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkRights returns the names of the required rights the bot lacks in the chat.
+func (m *Moderator) checkRights(ctx context.Context, chatId int64) ([]string, error) {
+	if err := m.CheckToken(); err != nil {
+		return nil, err
+	}
+	me := m.bot()
+	if me == nil {
+		return nil, errors.New("bot is unknown")
+	}
+
+	result, err := m.client.Call("getChatMember", map[string]interface{}{
+		"chat_id": chatId,
+		"user_id": me.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var member models.ChatMember
+	if err := json.Unmarshal(result, &member); err != nil {
+		return nil, err
+	}
+
+	missing := make([]string, 0)
+	if member.Status == string(types.Creator) {
+		return missing, nil
+	}
+	for _, right := range requiredRights {
+		if member.Status != string(types.Administrator) || !right.granted(member) {
+			missing = append(missing, right.name)
+		}
+	}
+
+	return missing, nil
+}
+
+func (m *Moderator) describeRights(rights []string, language string) string {
+	names := make([]string, 0, len(rights))
+	for _, right := range rights {
+		names = append(names, m.catalog.T(language, "perms.right_"+right))
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// checkPermsCommand tells the admins which rights the bot lacks in the chat.
+func (m *Moderator) checkPermsCommand(ctx context.Context, chatId int64, language string) string {
+	missing, err := m.checkRights(ctx, chatId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error checking bot rights", "chat_id", chatId, "error", err)
+		return m.catalog.T(language, "perms.check_failed")
+	}
+
+	if len(missing) == 0 {
+		return m.catalog.T(language, "perms.ok")
+	}
+
+	return m.catalog.T(language, "perms.missing", m.describeRights(missing, language))
+}
+
+// permissionAuditJob checks the rights of the bot in every known chat.
+func (m *Moderator) permissionAuditJob(job scheduler.Job) {
+	ctx := jobContext(job)
+	for _, chatId := range m.auditedChats() {
+		m.auditChat(ctx, chatId)
+	}
+}
+
+// auditChat reports missing rights to the log chat and the admins of the chat.
+// A chat is reported again only when the set of missing rights changes.
+func (m *Moderator) auditChat(ctx context.Context, chatId int64) {
+	missing, err := m.checkRights(ctx, chatId)
+	if err != nil {
+		slog.WarnContext(ctx, "Error auditing bot rights", "chat_id", chatId, "error", err)
+		return
+	}
+
+	key := strings.Join(missing, ",")
+	m.mu.Lock()
+	reported := m.missingRights[chatId]
+	m.missingRights[chatId] = key
+	m.mu.Unlock()
+
+	if key == reported || len(missing) == 0 {
+		return
+	}
+
+	slog.WarnContext(ctx, "Bot lacks rights", "chat_id", chatId, "missing", key)
+
+	language := m.chatLanguage(chatId)
+	text := m.catalog.T(language, "perms.alert", chatId, m.describeRights(missing, language))
+
+	if logChatId := m.settings.Chat(chatId).LogChatID; logChatId != 0 {
+		if err := m.sendNotice(ctx, logChatId, map[string]interface{}{"text": text}); err != nil {
+			slog.ErrorContext(ctx, "Error reporting missing rights to moderation log", "chat_id", chatId, "error", err)
+		}
+	}
+
+	result, err := m.client.Call("getChatAdministrators", map[string]interface{}{"chat_id": chatId})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting chat administrators", "chat_id", chatId, "error", err)
+		return
+	}
+
+	var admins []models.ChatMember
+	if err := json.Unmarshal(result, &admins); err != nil {
+		slog.ErrorContext(ctx, "Error decoding chat administrators", "chat_id", chatId, "error", err)
+		return
+	}
+
+	for _, admin := range admins {
+		if admin.User.IsBot {
+			continue
+		}
+		// only admins who started the bot can be written to
+		if _, err := m.client.Call("sendMessage", map[string]interface{}{
+			"chat_id": admin.User.ID,
+			"text":    text,
+		}); err != nil {
+			slog.DebugContext(ctx, "Error reporting missing rights to admin", "chat_id", chatId, "user_id", admin.User.ID, "error", err)
+		}
+	}
+}
+
+// handleMyChatMember audits a moderated chat right away when the rights of the bot change.
+func (m *Moderator) handleMyChatMember(ctx context.Context, update *models.ChatMemberUpdate) {
+	m.debug(update.Chat.ID, fmt.Sprintf("Bot status changed from %s to %s", update.OldChatMember.Status, update.NewChatMember.Status))
+
+	switch update.NewChatMember.Status {
+	case string(types.Administrator), string(types.Member), "restricted":
+		if m.moderated(update.Chat.ID) {
+			m.auditChat(ctx, update.Chat.ID)
+		}
+	default:
+		m.mu.Lock()
+		delete(m.seenChats, update.Chat.ID)
+		delete(m.missingRights, update.Chat.ID)
+		m.mu.Unlock()
+	}
+}
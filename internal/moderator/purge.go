@@ -0,0 +1,143 @@
+// internal/moderator/purge.go
+
+package moderator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"telegram_moderator/internal/recent"
+	"telegram_moderator/pkg/models"
+	"time"
+)
+
+// recentMessagesPerUser bounds the messages remembered for /purge per user and chat
+const recentMessagesPerUser = 200
+
+// recentMessagesTotal bounds the messages remembered for /purge altogether, a few MiB at most
+const recentMessagesTotal = 100000
+
+// rememberMessage records a group message for /purge.
+func (m *Moderator) rememberMessage(message *models.Message) {
+	if message.From.ID == 0 || message.Chat.Type == "private" {
+		return
+	}
+
+	at := m.clock.Now()
+	if message.Date != 0 {
+		at = time.Unix(message.Date, 0)
+	}
+
+	m.recentMessages.Add(message.Chat.ID, message.From.ID, message.MessageID, message.MediaGroupID, at)
+}
+
+// parsePurgeArgs reads "/purge [period]" in reply to a message of the user, or
+// "/purge <user id> [period]". The period defaults to everything remembered.
+func parsePurgeArgs(message *models.Message, args []string) (int64, time.Duration, bool) {
+	var userId int64
+	if message.ReplyToMessage != nil && message.ReplyToMessage.From.ID != 0 {
+		userId = message.ReplyToMessage.From.ID
+	} else {
+		if len(args) == 0 {
+			return 0, 0, false
+		}
+		parsed, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		userId = parsed
+		args = args[1:]
+	}
+
+	period := recent.MaxAge
+	if len(args) > 0 {
+		parsed, err := time.ParseDuration(args[0])
+		if err != nil || parsed <= 0 {
+			return 0, 0, false
+		}
+		period = parsed
+	}
+
+	return userId, period, true
+}
+
+// purgeCommand deletes the remembered messages of a user and records it in the moderation log.
+func (m *Moderator) purgeCommand(ctx context.Context, message *models.Message, args []string, language string) string {
+	userId, period, ok := parsePurgeArgs(message, args)
+	if !ok {
+		return m.catalog.T(language, "command.purge_usage")
+	}
+
+	chatId := message.Chat.ID
+	shadowed := m.shadowed(chatId)
+	var messageIds []int64
+	if shadowed {
+		// the messages stay remembered for a purge after shadow mode
+		messageIds = m.recentMessages.Since(chatId, userId, m.clock.Now().Add(-period))
+	} else {
+		messageIds = m.recentMessages.Take(chatId, userId, m.clock.Now().Add(-period))
+	}
+	if message.ReplyToMessage != nil && message.ReplyToMessage.From.ID == userId {
+		// the replied message may be older than the remembered ones
+		messageIds = append(messageIds, message.ReplyToMessage.MessageID)
+	}
+	messageIds = uniqueIds(messageIds)
+
+	if len(messageIds) == 0 {
+		return m.catalog.T(language, "command.purge_empty", userId)
+	}
+
+	if shadowed {
+		slog.InfoContext(ctx, "Purge skipped in shadow mode", "chat_id", chatId, "user_id", userId, "admin_id", message.From.ID, "count", len(messageIds), "period", period.String())
+	} else {
+		m.deleteMessages(ctx, chatId, messageIds)
+		slog.InfoContext(ctx, "Messages purged", "chat_id", chatId, "user_id", userId, "admin_id", message.From.ID, "count", len(messageIds), "period", period.String())
+	}
+
+	if logChatId := m.settings.Chat(chatId).LogChatID; logChatId != 0 {
+		user := fmt.Sprintf("id %d", userId)
+		if message.ReplyToMessage != nil && message.ReplyToMessage.From.ID == userId {
+			user = describeUser(userId, message.ReplyToMessage.From.FirstName, message.ReplyToMessage.From.Username)
+		}
+
+		tag := "#purged"
+		if shadowed {
+			tag = "#shadow nothing was purged"
+		}
+
+		lines := []string{
+			tag,
+			fmt.Sprintf("Chat: %s (id %d)", message.Chat.Title, chatId),
+			"User: " + user,
+			"Admin: " + describeUser(message.From.ID, message.From.FirstName, message.From.Username),
+			fmt.Sprintf("Messages: %d in the last %s", len(messageIds), period),
+			"Purged: " + m.clock.Now().UTC().Format(moderationLogTimeLayout),
+		}
+		if err := m.sendNotice(ctx, logChatId, map[string]interface{}{
+			"text": strings.Join(lines, "\n"),
+		}); err != nil {
+			slog.ErrorContext(ctx, "Error writing purge to moderation log", "chat_id", chatId, "error", err)
+		}
+	}
+
+	if shadowed {
+		return m.catalog.T(language, "command.purge_shadow", len(messageIds), userId)
+	}
+
+	return m.catalog.T(language, "command.purged", len(messageIds), userId)
+}
+
+func uniqueIds(ids []int64) []int64 {
+	seen := map[int64]bool{}
+	unique := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	return unique
+}
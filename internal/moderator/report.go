@@ -0,0 +1,146 @@
+// internal/moderator/report.go
+
+package moderator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"telegram_moderator/internal/appeal"
+	"telegram_moderator/internal/config"
+	"telegram_moderator/pkg/models"
+	"text/template"
+)
+
+// reportActionPrefix starts the action keys of the reports, a report sent on retry is cleaned up too
+const reportActionPrefix = "report:"
+
+// example of map: reportTemplates.Store(templateText, *template.Template)
+var reportTemplates = sync.Map{}
+
+// reportData holds the placeholders of a report template. Every text field is already
+// HTML escaped, so templates can be written in Telegram HTML without escaping them again.
+type reportData struct {
+	// Mention links the user's name to their profile
+	Mention   string
+	Name      string
+	Username  string
+	UserID    int64
+	Reason    string
+	URLs      string
+	PostLink  string
+	ChatTitle string
+}
+
+func newReportData(c appeal.Case, reason string) reportData {
+	data := reportData{
+		Mention:   fmt.Sprintf(`<a href="tg://user?id=%d">%s</a>`, c.UserID, html.EscapeString(c.FirstName)),
+		Name:      html.EscapeString(c.FirstName),
+		Username:  html.EscapeString(c.Username),
+		UserID:    c.UserID,
+		Reason:    html.EscapeString(reason),
+		URLs:      html.EscapeString(strings.Join(c.URLs, ", ")),
+		ChatTitle: html.EscapeString(c.ChatTitle),
+	}
+
+	if c.PostMessageID != 0 {
+		data.PostLink = postLink(c.ChatID, c.PostMessageID)
+	}
+
+	return data
+}
+
+// postLink builds the t.me/c link of a message in a supergroup, which opens for its members.
+func postLink(chatId int64, messageId int64) string {
+	internalId := strings.TrimPrefix(strconv.FormatInt(chatId, 10), "-100")
+	return "https://t.me/c/" + internalId + "/" + strconv.FormatInt(messageId, 10)
+}
+
+func renderReport(templateText string, data reportData) (string, error) {
+	var tmpl *template.Template
+	if cached, ok := reportTemplates.Load(templateText); ok {
+		tmpl = cached.(*template.Template)
+	} else {
+		parsed, err := template.New("report").Parse(templateText)
+		if err != nil {
+			return "", err
+		}
+		reportTemplates.Store(templateText, parsed)
+		tmpl = parsed
+	}
+
+	var text bytes.Buffer
+	if err := tmpl.Execute(&text, data); err != nil {
+		return "", err
+	}
+
+	return text.String(), nil
+}
+
+// sendDeletionReport posts the report about a deleted message in reply to the post
+// and lets its author appeal when the chat has a log chat for the admins.
+func (m *Moderator) sendDeletionReport(ctx context.Context, c appeal.Case, reason string, penalty config.PenaltyStep) {
+	settings := m.settings.Chat(c.ChatID)
+	if settings.SuppressReports {
+		m.debug(c.ChatID, "Public reports are suppressed, not sending report.")
+		return
+	}
+
+	language := m.chatLanguage(c.ChatID)
+	defaultTemplate := m.catalog.T(language, "report.template")
+	data := newReportData(c, m.catalog.T(language, "reason."+reason))
+
+	templateText := settings.ReportTemplate
+	if templateText == "" {
+		templateText = defaultTemplate
+	}
+
+	reportText, err := renderReport(templateText, data)
+	if err != nil {
+		slog.WarnContext(ctx, "Error rendering report template, using the default", "chat_id", c.ChatID, "error", err)
+		reportText, _ = renderReport(defaultTemplate, data)
+	}
+
+	m.debug(c.ChatID, "report text: "+reportText)
+
+	params := map[string]interface{}{
+		"chat_id":    c.ChatID,
+		"text":       reportText,
+		"parse_mode": "HTML",
+	}
+	if c.PostMessageID != 0 {
+		params["reply_to_message_id"] = c.PostMessageID
+	}
+
+	if link := m.appealLink(c.ChatID, c.ID); link != "" {
+		c.Penalty = string(penalty.Action)
+		if err := m.appeals.Open(c); err != nil {
+			slog.ErrorContext(ctx, "Error saving appeal case", "chat_id", c.ChatID, "error", err)
+		} else {
+			params["reply_markup"] = map[string][][]map[string]string{
+				"inline_keyboard": {{{"text": m.catalog.T(language, "report.appeal_button"), "url": link}}},
+			}
+		}
+	}
+
+	result, err := m.runAction(ctx, reportActionPrefix+c.ID, "sendMessage", c.ChatID, params)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error sending report", "chat_id", c.ChatID, "error", err)
+		m.debug(c.ChatID, "Error sending message")
+		return
+	}
+
+	var sent models.Message
+	if err := json.Unmarshal(result, &sent); err != nil {
+		slog.ErrorContext(ctx, "Error parsing report response", "chat_id", c.ChatID, "error", err)
+		return
+	}
+
+	m.scheduleCleanup(ctx, c.ChatID, sent.MessageID)
+}
@@ -0,0 +1,210 @@
+// internal/moderator/review.go
+
+package moderator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"telegram_moderator/internal/appeal"
+	"telegram_moderator/internal/config"
+	"telegram_moderator/internal/metrics"
+	"telegram_moderator/internal/review"
+	"telegram_moderator/pkg/models"
+	"time"
+)
+
+const reviewCallbackPrefix = "review:"
+
+// holdForReview posts the message to the log chat with buttons for the admins to decide on it,
+// in reply to its copy, then removes it and the bot messages about it from the chat.
+// Nothing is deleted when the review can't be posted or stored.
+func (m *Moderator) holdForReview(ctx context.Context, pending *pendingVerification, reason string, evidenceMessageId int64, botMessageIds ...int64) error {
+	settings := m.settings.Chat(pending.ChatID)
+	now := m.clock.Now()
+
+	item := review.Item{
+		ID:            review.ItemID(pending.ChatID, pending.UserMessageID),
+		ChatID:        pending.ChatID,
+		ChatTitle:     pending.ChatTitle,
+		UserID:        pending.UserID,
+		Username:      pending.Username,
+		FirstName:     pending.FirstName,
+		LanguageCode:  pending.LanguageCode,
+		MessageID:     pending.UserMessageID,
+		PostMessageID: pending.PostMessageID,
+		Text:          pending.Text,
+		URLs:          pending.URLs,
+		Reason:        reason,
+		Date:          pending.Date,
+		QueuedAt:      now,
+		LogChatID:     settings.LogChatID,
+	}
+	if settings.ReviewExpiry > 0 {
+		item.ExpiresAt = now.Add(time.Duration(settings.ReviewExpiry))
+	}
+
+	urls := strings.Join(item.URLs, ", ")
+	if urls == "" {
+		urls = "-"
+	}
+
+	lines := []string{
+		"#review " + reason,
+		fmt.Sprintf("Chat: %s (id %d)", item.ChatTitle, item.ChatID),
+		"User: " + describeUser(item.UserID, item.FirstName, item.Username),
+		"URLs: " + urls,
+		"Sent: " + item.Date.UTC().Format(moderationLogTimeLayout),
+		"Text: " + item.Text,
+	}
+	if !item.ExpiresAt.IsZero() {
+		lines = append(lines, fmt.Sprintf("Expires: %s (then %s)", item.ExpiresAt.UTC().Format(moderationLogTimeLayout), settings.ReviewExpiryAction))
+	}
+	text := strings.Join(lines, "\n")
+
+	params := map[string]interface{}{
+		"chat_id":      settings.LogChatID,
+		"text":         text,
+		"reply_markup": reviewKeyboard(item.ID),
+	}
+	if evidenceMessageId != 0 {
+		params["reply_to_message_id"] = evidenceMessageId
+	}
+
+	result, err := m.client.Call("sendMessage", params)
+	if err != nil {
+		return err
+	}
+
+	var sent models.Message
+	if err := json.Unmarshal(result, &sent); err != nil {
+		return err
+	}
+	item.LogMessageID = sent.MessageID
+
+	if err := m.reviews.Add(item); err != nil {
+		// the buttons of the post would find no item
+		m.deleteMessage(ctx, settings.LogChatID, sent.MessageID)
+		return err
+	}
+
+	userMessageIds := m.userMessageIds(pending)
+	m.deleteMessages(ctx, pending.ChatID, append(userMessageIds, botMessageIds...))
+	m.recentMessages.Forget(pending.ChatID, pending.UserID, userMessageIds...)
+	metrics.Deletions.Inc()
+
+	if !item.ExpiresAt.IsZero() {
+		m.scheduleReviewExpiry(ctx, item.ID, item.ExpiresAt)
+	}
+
+	return nil
+}
+
+func reviewKeyboard(itemId string) map[string][][]map[string]string {
+	return map[string][][]map[string]string{
+		"inline_keyboard": {
+			{
+				{"text": "Approve", "callback_data": reviewCallbackPrefix + string(config.ReviewApprove) + ":" + itemId},
+				{"text": "Delete", "callback_data": reviewCallbackPrefix + string(config.ReviewDelete) + ":" + itemId},
+				{"text": "Ban", "callback_data": reviewCallbackPrefix + string(config.ReviewBan) + ":" + itemId},
+			},
+		},
+	}
+}
+
+func (m *Moderator) handleReviewCallback(ctx context.Context, callbackQuery *models.CallbackQuery) {
+	language := m.privateLanguage(callbackQuery.From.LanguageCode)
+
+	decision, itemId, found := strings.Cut(strings.TrimPrefix(callbackQuery.Data, reviewCallbackPrefix), ":")
+	if !found {
+		m.answerCallbackQuery(ctx, callbackQuery.ID, m.catalog.T(language, "toast.unknown_action"))
+		return
+	}
+
+	item, ok := m.reviews.Get(itemId)
+	if !ok {
+		m.answerCallbackQuery(ctx, callbackQuery.ID, m.catalog.T(language, "toast.already_reviewed"))
+		return
+	}
+
+	language = m.userLanguage(item.ChatID, callbackQuery.From.LanguageCode)
+
+	if !m.isChatAdmin(ctx, item.ChatID, callbackQuery.From.ID) {
+		m.answerCallbackQuery(ctx, callbackQuery.ID, m.catalog.T(language, "toast.admins_only"))
+		return
+	}
+
+	item, ok, err := m.reviews.Take(itemId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error removing review item", "item_id", itemId, "error", err)
+	}
+	if !ok {
+		m.answerCallbackQuery(ctx, callbackQuery.ID, m.catalog.T(language, "toast.already_reviewed"))
+		return
+	}
+
+	m.jobs.Cancel(reviewJobKey(item.ID))
+
+	status := m.applyReviewDecision(ctx, item, config.ReviewDecision(decision), describeUser(callbackQuery.From.ID, callbackQuery.From.FirstName, callbackQuery.From.Username), language)
+	m.answerCallbackQuery(ctx, callbackQuery.ID, status)
+}
+
+// applyReviewDecision carries out the decision on a taken item and notes it in the log entry.
+func (m *Moderator) applyReviewDecision(ctx context.Context, item review.Item, decision config.ReviewDecision, decidedBy string, language string) string {
+	var status string
+
+	switch decision {
+	case config.ReviewApprove:
+		status = m.catalog.T(language, "toast.approved")
+		repost := m.catalog.T(m.chatLanguage(item.ChatID), "repost.text", item.FirstName, item.Text)
+		if _, err := m.sendMessage(ctx, item.ChatID, item.PostMessageID, repost); err != nil {
+			slog.ErrorContext(ctx, "Error reposting approved message", "chat_id", item.ChatID, "error", err)
+			status = m.catalog.T(language, "toast.approved_repost_failed")
+		}
+		if err := m.verified.Add(item.ChatID, item.UserID, item.Username, item.FirstName, m.clock.Now()); err != nil {
+			slog.ErrorContext(ctx, "Error saving verified user", "chat_id", item.ChatID, "user_id", item.UserID, "error", err)
+		}
+	case config.ReviewBan:
+		status = m.catalog.T(language, "toast.banned")
+		if err := m.banWithHistory(ctx, item.ChatID, item.UserID); err != nil {
+			slog.ErrorContext(ctx, "Error banning user", "chat_id", item.ChatID, "user_id", item.UserID, "error", err)
+			status = m.catalog.T(language, "toast.ban_failed")
+		}
+	default:
+		strike, penalty := m.punishFailedVerification(ctx, item.ChatID, item.UserID)
+		status = m.catalog.T(language, "toast.deleted", describePenalty(penalty), strike)
+		m.sendDeletionReport(ctx, appeal.Case{
+			ID:            appeal.CaseID(item.ChatID, item.MessageID),
+			ChatID:        item.ChatID,
+			ChatTitle:     item.ChatTitle,
+			UserID:        item.UserID,
+			Username:      item.Username,
+			FirstName:     item.FirstName,
+			LanguageCode:  item.LanguageCode,
+			PostMessageID: item.PostMessageID,
+			Text:          item.Text,
+			URLs:          item.URLs,
+			DeletedAt:     m.clock.Now(),
+		}, item.Reason, penalty)
+	}
+
+	_, err := m.client.Call("editMessageReplyMarkup", map[string]interface{}{
+		"chat_id":      item.LogChatID,
+		"message_id":   item.LogMessageID,
+		"reply_markup": map[string][][]map[string]string{"inline_keyboard": {}},
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error removing review buttons", "item_id", item.ID, "error", err)
+	}
+
+	if err := m.sendNotice(ctx, item.LogChatID, map[string]interface{}{
+		"text":                m.catalog.T(language, "toast.by", status, decidedBy),
+		"reply_to_message_id": item.LogMessageID,
+	}); err != nil {
+		slog.ErrorContext(ctx, "Error noting review decision", "item_id", item.ID, "error", err)
+	}
+
+	return status
+}
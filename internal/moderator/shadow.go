@@ -0,0 +1,140 @@
+// internal/moderator/shadow.go
+
+package moderator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"telegram_moderator/internal/shadow"
+	"time"
+)
+
+// shadowSummaryPeriod is the default period of the /shadow command
+const shadowSummaryPeriod = 7 * 24 * time.Hour
+
+// shadowSummaryEntries is how many recent messages the /shadow command lists
+const shadowSummaryEntries = 10
+
+// shadowed tells whether the chat runs in shadow mode, where the bot deletes nothing.
+func (m *Moderator) shadowed(chatId int64) bool {
+	return m.settings.Chat(chatId).Shadow
+}
+
+// shadowVerification records what the verification would do in a chat running in shadow mode.
+// Nobody can answer a question that is never sent, so the outcome is the one of a timeout:
+// the message is deleted and the user gets the next escalation step.
+func (m *Moderator) shadowVerification(ctx context.Context, pending *pendingVerification) {
+	settings := m.settings.Chat(pending.ChatID)
+	now := m.clock.Now()
+	decay := time.Duration(settings.StrikeDecay)
+
+	// strikes aren't added in shadow mode, the earlier shadow entries stand in for them
+	strike := m.strikes.Get(pending.ChatID, pending.UserID, decay, now) + 1
+	since := time.Time{}
+	if decay > 0 {
+		since = now.Add(-decay)
+	}
+	for _, entry := range m.shadowLog.Since(pending.ChatID, since) {
+		if entry.UserID == pending.UserID {
+			strike++
+		}
+	}
+	penalty := settings.Penalty(strike)
+
+	entry := shadow.Entry{
+		ChatID:    pending.ChatID,
+		MessageID: pending.UserMessageID,
+		UserID:    pending.UserID,
+		Username:  pending.Username,
+		FirstName: pending.FirstName,
+		Text:      pending.Text,
+		URLs:      pending.URLs,
+		Penalty:   describePenalty(penalty),
+		Strike:    strike,
+		At:        now,
+	}
+	if err := m.shadowLog.Add(entry); err != nil {
+		slog.ErrorContext(ctx, "Error saving shadow entry", "chat_id", pending.ChatID, "error", err)
+	}
+
+	m.debug(pending.ChatID, fmt.Sprintf("Shadow mode, would verify message %d of user %d", pending.UserMessageID, pending.UserID))
+
+	if settings.LogChatID == 0 {
+		return
+	}
+
+	lines := []string{
+		"#shadow nothing was done",
+		fmt.Sprintf("Chat: %s (id %d)", pending.ChatTitle, pending.ChatID),
+		"User: " + describeUser(pending.UserID, pending.FirstName, pending.Username),
+		"URLs: " + strings.Join(pending.URLs, ", "),
+		"Sent: " + pending.Date.UTC().Format(moderationLogTimeLayout),
+		fmt.Sprintf("Would ask the verification question, then delete message %d and apply %s (strike %d) without an answer", pending.UserMessageID, entry.Penalty, strike),
+		"Text: " + pending.Text,
+	}
+
+	if err := m.sendNotice(ctx, settings.LogChatID, map[string]interface{}{
+		"text": strings.Join(lines, "\n"),
+	}); err != nil {
+		slog.ErrorContext(ctx, "Error writing shadow entry to moderation log", "chat_id", pending.ChatID, "error", err)
+	}
+}
+
+// shadowCommand summarizes what the bot would have removed, args may hold the period like "24h".
+func (m *Moderator) shadowCommand(chatId int64, args []string, language string) string {
+	period := shadowSummaryPeriod
+	if len(args) > 0 {
+		parsed, err := time.ParseDuration(args[0])
+		if err != nil || parsed <= 0 {
+			return m.catalog.T(language, "command.shadow_usage")
+		}
+		period = parsed
+	}
+
+	entries := m.shadowLog.Since(chatId, m.clock.Now().Add(-period))
+
+	lines := make([]string, 0)
+	if !m.shadowed(chatId) {
+		lines = append(lines, m.catalog.T(language, "command.shadow_off"))
+	}
+	if len(entries) == 0 {
+		lines = append(lines, m.catalog.T(language, "command.shadow_empty", period.String()))
+		return strings.Join(lines, "\n")
+	}
+
+	users := map[int64]bool{}
+	penalties := map[string]int{}
+	for _, entry := range entries {
+		users[entry.UserID] = true
+		penalties[entry.Penalty]++
+	}
+
+	names := make([]string, 0, len(penalties))
+	for name := range penalties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	counts := make([]string, 0, len(names))
+	for _, name := range names {
+		counts = append(counts, fmt.Sprintf("%s %d", name, penalties[name]))
+	}
+
+	lines = append(lines,
+		m.catalog.T(language, "command.shadow_summary", period.String(), len(entries), len(users)),
+		m.catalog.T(language, "command.shadow_penalties", strings.Join(counts, ", ")),
+	)
+
+	if len(entries) > shadowSummaryEntries {
+		entries = entries[len(entries)-shadowSummaryEntries:]
+	}
+	lines = append(lines, m.catalog.T(language, "command.shadow_recent"))
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		lines = append(lines, fmt.Sprintf("%s %s: %s", entry.At.UTC().Format("2006-01-02 15:04"), describeUser(entry.UserID, entry.FirstName, entry.Username), strings.Join(entry.URLs, ", ")))
+	}
+
+	return strings.Join(lines, "\n")
+}
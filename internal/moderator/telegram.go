@@ -0,0 +1,155 @@
+// internal/moderator/telegram.go
+
+package moderator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"telegram_moderator/pkg/models"
+)
+
+func (m *Moderator) sendMessage(ctx context.Context, chatId int64, messageId int64, text string) (int64, error) {
+	m.debug(chatId, "Trying to send message. Text: "+text)
+
+	params := map[string]interface{}{
+		"chat_id": chatId,
+		"text":    text,
+	}
+	if messageId != 0 {
+		params["reply_to_message_id"] = messageId
+	}
+
+	result, err := m.client.Call("sendMessage", params)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error sending message", "chat_id", chatId, "error", err)
+		m.debug(chatId, "Error sending message")
+		return 0, err
+	}
+
+	m.debug(chatId, fmt.Sprintf("Send message response: %s", string(result)))
+
+	var sent models.Message
+	if err := json.Unmarshal(result, &sent); err != nil {
+		return 0, err
+	}
+	slog.DebugContext(ctx, "Message sent", "chat_id", chatId, "message_id", sent.MessageID)
+
+	return sent.MessageID, nil
+}
+
+func (m *Moderator) deleteMessage(ctx context.Context, chatId int64, messageId int64) {
+	result, err := m.runAction(ctx, fmt.Sprintf("delete:%d:%d", chatId, messageId), "deleteMessage", chatId, map[string]interface{}{
+		"chat_id":    chatId,
+		"message_id": messageId,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error deleting message", "chat_id", chatId, "message_id", messageId, "error", err)
+		m.debug(chatId, "Error deleting message")
+		return
+	}
+
+	slog.DebugContext(ctx, "Message deleted", "chat_id", chatId, "message_id", messageId)
+	m.debug(chatId, fmt.Sprintf("Delete message response: %s, message id is %d", string(result), messageId))
+}
+
+// deleteMessagesLimit is the most message ids deleteMessages takes at once
+const deleteMessagesLimit = 100
+
+// deleteMessages removes the messages of a chat with deleteMessages, up to 100 per call.
+// A Bot API server without the method gets one deleteMessage per message.
+func (m *Moderator) deleteMessages(ctx context.Context, chatId int64, messageIds []int64) {
+	ids := make([]int64, 0, len(messageIds))
+	for _, messageId := range messageIds {
+		if messageId != 0 {
+			ids = append(ids, messageId)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for len(ids) > 0 {
+		if len(ids) == 1 || m.noBatchDelete.Load() {
+			for _, messageId := range ids {
+				m.deleteMessage(ctx, chatId, messageId)
+			}
+			return
+		}
+
+		batch := ids
+		if len(batch) > deleteMessagesLimit {
+			batch = batch[:deleteMessagesLimit]
+		}
+
+		key := fmt.Sprintf("delete:%d:%d+%d", chatId, batch[0], len(batch))
+		_, err := m.runAction(ctx, key, "deleteMessages", chatId, map[string]interface{}{
+			"chat_id":     chatId,
+			"message_ids": batch,
+		})
+		if unsupported("deleteMessages", err) {
+			m.disableBatchDelete(ctx, err)
+			continue
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Error deleting messages", "chat_id", chatId, "count", len(batch), "error", err)
+		} else {
+			m.debug(chatId, fmt.Sprintf("Deleted messages %v", batch))
+		}
+
+		ids = ids[len(batch):]
+	}
+}
+
+// disableBatchDelete switches to one deleteMessage per message after the Bot API server
+// turned out to have no deleteMessages.
+func (m *Moderator) disableBatchDelete(ctx context.Context, err error) {
+	if !m.noBatchDelete.Swap(true) {
+		slog.WarnContext(ctx, "Bot API server has no deleteMessages, deleting one message at a time", "error", err)
+	}
+}
+
+// CheckToken validates the bot token with getMe. The bot is remembered after the first
+// success, later calls don't reach the Bot API.
+func (m *Moderator) CheckToken() error {
+	m.meMu.Lock()
+	defer m.meMu.Unlock()
+
+	if m.me != nil {
+		return nil
+	}
+
+	result, err := m.client.Call("getMe", map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+
+	var me models.User
+	if err := json.Unmarshal(result, &me); err != nil {
+		return err
+	}
+
+	m.me = &me
+	slog.Info("Bot token validated", "bot_username", me.Username, "bot_id", me.ID)
+	return nil
+}
+
+// bot returns the bot itself, nil until getMe succeeded.
+func (m *Moderator) bot() *models.User {
+	m.meMu.Lock()
+	defer m.meMu.Unlock()
+
+	return m.me
+}
+
+// username is BOT_USERNAME, or the username getMe reported when it is not set.
+func (m *Moderator) username() string {
+	if m.botUsername != "" {
+		return m.botUsername
+	}
+	if me := m.bot(); me != nil {
+		return me.Username
+	}
+
+	return ""
+}
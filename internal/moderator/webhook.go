@@ -0,0 +1,77 @@
+// internal/moderator/webhook.go
+
+package moderator
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"telegram_moderator/internal/metrics"
+	"telegram_moderator/internal/scheduler"
+	"telegram_moderator/pkg/models"
+	"time"
+)
+
+// webhookState is what the monitor already reported to the owner.
+type webhookState struct {
+	errorDate   int64
+	urlMismatch bool
+	lastMessage string
+}
+
+// webhookCheckJob reads getWebhookInfo into the metrics and alerts the owner chat
+// about new delivery errors and a webhook URL that differs from the configured one.
+func (m *Moderator) webhookCheckJob(job scheduler.Job) {
+	ctx := jobContext(job)
+	result, err := m.client.Call("getWebhookInfo", map[string]interface{}{})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting webhook info", "error", err)
+		return
+	}
+
+	var info models.WebhookInfo
+	if err := json.Unmarshal(result, &info); err != nil {
+		slog.ErrorContext(ctx, "Error decoding webhook info", "error", err)
+		return
+	}
+
+	metrics.WebhookPendingUpdates.Set(float64(info.PendingUpdateCount))
+	metrics.WebhookLastErrorDate.Set(float64(info.LastErrorDate))
+
+	m.webhookMu.Lock()
+	state := m.webhook
+	if info.LastErrorMessage != state.lastMessage {
+		metrics.WebhookLastError.DeleteLabelValues(state.lastMessage)
+	}
+	if info.LastErrorMessage != "" {
+		metrics.WebhookLastError.WithLabelValues(info.LastErrorMessage).Set(1)
+	}
+
+	newError := info.LastErrorDate != 0 && info.LastErrorDate != state.errorDate
+	urlMismatch := m.webhookURL != "" && info.URL != m.webhookURL
+	m.webhook = webhookState{errorDate: info.LastErrorDate, urlMismatch: urlMismatch, lastMessage: info.LastErrorMessage}
+	m.webhookMu.Unlock()
+
+	language := m.chatLanguage(m.ownerChatID)
+
+	if newError {
+		date := time.Unix(info.LastErrorDate, 0).UTC().Format(moderationLogTimeLayout)
+		slog.WarnContext(ctx, "Webhook delivery failed", "date", date, "message", info.LastErrorMessage, "pending_updates", info.PendingUpdateCount)
+		m.alertOwner(ctx, m.catalog.T(language, "webhook.error", date, info.LastErrorMessage, info.PendingUpdateCount))
+	}
+	if urlMismatch && !state.urlMismatch {
+		slog.WarnContext(ctx, "Webhook URL mismatch", "url", info.URL, "expected", m.webhookURL)
+		m.alertOwner(ctx, m.catalog.T(language, "webhook.url_mismatch", info.URL, m.webhookURL))
+	}
+}
+
+// alertOwner writes to the owner chat, the alert is only logged without one.
+func (m *Moderator) alertOwner(ctx context.Context, text string) {
+	if m.ownerChatID == 0 {
+		return
+	}
+
+	if err := m.sendNotice(ctx, m.ownerChatID, map[string]interface{}{"text": text}); err != nil {
+		slog.ErrorContext(ctx, "Error alerting owner chat", "error", err)
+	}
+}
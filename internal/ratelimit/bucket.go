@@ -0,0 +1,70 @@
+// internal/ratelimit/bucket.go
+
+package ratelimit
+
+import "time"
+
+// bucket is a token bucket refilled at rate tokens per second up to burst tokens.
+type bucket struct {
+	rate        float64
+	burst       float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+func newBucket(rate float64, burst float64) *bucket {
+	return &bucket{rate: rate, burst: burst, tokens: burst}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	if b.last.IsZero() || now.After(b.last) {
+		b.last = now
+	}
+}
+
+// wait is how long until a token is available, zero when one is.
+func (b *bucket) wait(now time.Time) time.Duration {
+	b.refill(now)
+
+	if now.Before(b.pausedUntil) {
+		return b.pausedUntil.Sub(now)
+	}
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *bucket) take() {
+	b.tokens--
+}
+
+// interval is the time one token takes to refill.
+func (b *bucket) interval() time.Duration {
+	return time.Duration(float64(time.Second) / b.rate)
+}
+
+// pause holds the calls until the time, like after a 429, then lets a single one through.
+func (b *bucket) pause(until time.Time) {
+	if until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+	if until.After(b.last) {
+		// nothing refills while paused
+		b.last = until
+	}
+	b.tokens = 1
+}
+
+func (b *bucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst && !now.Before(b.pausedUntil)
+}
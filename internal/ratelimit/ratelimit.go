@@ -0,0 +1,251 @@
+// internal/ratelimit/ratelimit.go
+
+// Package ratelimit throttles the Bot API calls to the limits of Telegram, so a spam wave
+// doesn't end in 429 Too Many Requests.
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"telegram_moderator/internal/clock"
+	"telegram_moderator/internal/telegram"
+	"time"
+)
+
+// Limits are the rates the client keeps to.
+type Limits struct {
+	// Global is the number of calls per second to all chats
+	Global float64
+	// PerChat is the number of messages per minute sent to one group
+	PerChat float64
+	// MaxRetryAfter is the longest retry_after of a 429 the call waits for before it is
+	// retried, longer ones return the error for the retry queue of the caller. It also caps
+	// how long a 429 pauses the chat.
+	MaxRetryAfter time.Duration
+	// Retries is how many times a call is retried right away after a 429
+	Retries int
+}
+
+// DefaultLimits are the limits documented for bots.
+var DefaultLimits = Limits{
+	Global:        30,
+	PerChat:       20,
+	MaxRetryAfter: 5 * time.Second,
+	Retries:       1,
+}
+
+// sendMethods post messages into the chat and count against its limit.
+var sendMethods = map[string]bool{
+	"sendMessage":    true,
+	"copyMessage":    true,
+	"forwardMessage": true,
+}
+
+// urgentMethods remove spam, they go before the reports and logs waiting for their turn.
+var urgentMethods = map[string]bool{
+	"deleteMessage":      true,
+	"deleteMessages":     true,
+	"restrictChatMember": true,
+	"banChatMember":      true,
+}
+
+// pruneChats is the number of chat buckets above which the full ones are forgotten
+const pruneChats = 1000
+
+// Client throttles the calls of the wrapped Bot API client with a global token bucket
+// and one bucket per group, and retries the calls Telegram answers with 429.
+type Client struct {
+	next   telegram.Client
+	limits Limits
+	clock  clock.Clock
+	sleep  func(time.Duration)
+
+	mu     sync.Mutex
+	global *bucket
+	chats  map[int64]*bucket
+	// paused holds the chats a 429 stopped until the time, for every method
+	paused map[int64]time.Time
+	// urgent counts the urgent calls waiting for a token
+	urgent int
+}
+
+func NewClient(next telegram.Client, limits Limits) *Client {
+	return &Client{
+		next:   next,
+		limits: limits,
+		clock:  clock.Real{},
+		sleep:  time.Sleep,
+		global: newBucket(limits.Global, limits.Global),
+		chats:  map[int64]*bucket{},
+		paused: map[int64]time.Time{},
+	}
+}
+
+func (c *Client) Call(method string, params interface{}) (json.RawMessage, error) {
+	chatId := chatIdOf(params)
+
+	for attempt := 0; ; attempt++ {
+		c.acquire(method, chatId)
+
+		result, err := c.next.Call(method, params)
+
+		var apiErr *telegram.APIError
+		if !errors.As(err, &apiErr) || apiErr.Code != http.StatusTooManyRequests {
+			return result, err
+		}
+
+		retryAfter := time.Duration(apiErr.Parameters.RetryAfter) * time.Second
+		slog.Warn("Bot API rate limit hit", "method", method, "chat_id", chatId, "retry_after", retryAfter.String(), "attempt", attempt+1)
+
+		// the 429 is a temporary error, callers with a retry queue wait out longer pauses there
+		giveUp := attempt >= c.limits.Retries || retryAfter > c.limits.MaxRetryAfter
+		c.pause(chatId, retryAfter)
+		if giveUp {
+			return result, err
+		}
+	}
+}
+
+// acquire waits for a token of the global bucket and, for messages to a group, of its bucket.
+// Every call to a chat paused by a 429 waits for the pause. Other calls yield to the urgent
+// ones that only wait for a global token.
+func (c *Client) acquire(method string, chatId int64) {
+	urgent := urgentMethods[method]
+	limitChat := sendMethods[method] && chatId < 0
+	// queued is set while this urgent call counts as waiting for the global bucket
+	queued := false
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	defer func() {
+		if queued {
+			c.urgent--
+		}
+	}()
+
+	for {
+		now := c.clock.Now()
+
+		// chatWait is the wait of the chat alone, a paused chat doesn't hold back other chats
+		var chatWait time.Duration
+		if until, ok := c.paused[chatId]; ok {
+			if pauseWait := until.Sub(now); pauseWait > 0 {
+				chatWait = pauseWait
+			} else {
+				delete(c.paused, chatId)
+			}
+		}
+		var chat *bucket
+		if limitChat {
+			chat = c.chat(chatId)
+			if bucketWait := chat.wait(now); bucketWait > chatWait {
+				chatWait = bucketWait
+			}
+		}
+
+		if urgent && queued != (chatWait == 0) {
+			queued = chatWait == 0
+			if queued {
+				c.urgent++
+			} else {
+				c.urgent--
+			}
+		}
+
+		wait := c.global.wait(now)
+		if chatWait > wait {
+			wait = chatWait
+		}
+		if !urgent && c.urgent > 0 && wait == 0 {
+			// let the urgent calls take the next token
+			wait = c.global.interval()
+		}
+
+		if wait == 0 {
+			c.global.take()
+			if chat != nil {
+				chat.take()
+			}
+			return
+		}
+
+		c.mu.Unlock()
+		c.sleep(wait)
+		c.mu.Lock()
+	}
+}
+
+// pause stops every call to the chat, or all calls when chatId is zero, for retryAfter
+// but no longer than MaxRetryAfter.
+func (c *Client) pause(chatId int64, retryAfter time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if retryAfter > c.limits.MaxRetryAfter {
+		retryAfter = c.limits.MaxRetryAfter
+	}
+
+	now := c.clock.Now()
+	until := now.Add(retryAfter)
+	if chatId == 0 {
+		c.global.pause(until)
+		return
+	}
+
+	if len(c.paused) >= pruneChats {
+		for id, other := range c.paused {
+			if !now.Before(other) {
+				delete(c.paused, id)
+			}
+		}
+	}
+	if until.After(c.paused[chatId]) {
+		c.paused[chatId] = until
+	}
+}
+
+func (c *Client) chat(chatId int64) *bucket {
+	b, ok := c.chats[chatId]
+	if ok {
+		return b
+	}
+
+	if len(c.chats) >= pruneChats {
+		now := c.clock.Now()
+		for id, other := range c.chats {
+			if other.full(now) {
+				delete(c.chats, id)
+			}
+		}
+	}
+
+	b = newBucket(c.limits.PerChat/60, c.limits.PerChat)
+	c.chats[chatId] = b
+	return b
+}
+
+// chatIdOf is the numeric chat_id of the call, zero when there is none.
+func chatIdOf(params interface{}) int64 {
+	p, ok := params.(map[string]interface{})
+	if !ok {
+		return 0
+	}
+
+	switch chatId := p["chat_id"].(type) {
+	case int64:
+		return chatId
+	case int:
+		return int64(chatId)
+	case float64:
+		return int64(chatId)
+	case json.Number:
+		value, _ := chatId.Int64()
+		return value
+	}
+
+	return 0
+}
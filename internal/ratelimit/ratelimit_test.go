@@ -0,0 +1,244 @@
+// internal/ratelimit/ratelimit_test.go
+
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"telegram_moderator/internal/clock"
+	"telegram_moderator/internal/telegram"
+	"testing"
+	"time"
+)
+
+// fakeAPI records when each call reached it and answers the first ones with 429.
+type fakeAPI struct {
+	clock       *clock.Fake
+	calls       []time.Time
+	tooMany     int
+	retryAfter  int
+	methodsSeen []string
+}
+
+func (f *fakeAPI) Call(method string, params interface{}) (json.RawMessage, error) {
+	f.calls = append(f.calls, f.clock.Now())
+	f.methodsSeen = append(f.methodsSeen, method)
+	if f.tooMany > 0 {
+		f.tooMany--
+		return nil, &telegram.APIError{
+			Method:      method,
+			Code:        http.StatusTooManyRequests,
+			Description: "Too Many Requests: retry after",
+			Parameters:  telegram.ResponseParameters{RetryAfter: f.retryAfter},
+		}
+	}
+
+	return json.RawMessage("true"), nil
+}
+
+func newTestClient(api *fakeAPI, limits Limits) *Client {
+	c := NewClient(api, limits)
+	c.clock = api.clock
+	c.sleep = api.clock.Advance
+	return c
+}
+
+func TestLimits(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		method   string
+		chatId   int64
+		calls    int
+		wantLast time.Duration
+	}{
+		{name: "global burst", method: "deleteMessage", chatId: -100, calls: 30, wantLast: 0},
+		{name: "global rate", method: "deleteMessage", chatId: -100, calls: 60, wantLast: time.Second},
+		{name: "group burst", method: "sendMessage", chatId: -100, calls: 20, wantLast: 0},
+		{name: "group rate", method: "sendMessage", chatId: -100, calls: 23, wantLast: 9 * time.Second},
+		{name: "private chats only count globally", method: "sendMessage", chatId: 42, calls: 23, wantLast: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &fakeAPI{clock: clock.NewFake(start)}
+			c := newTestClient(api, DefaultLimits)
+
+			for i := 0; i < tt.calls; i++ {
+				if _, err := c.Call(tt.method, map[string]interface{}{"chat_id": tt.chatId}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if last := api.calls[len(api.calls)-1].Sub(start); last.Round(time.Millisecond) != tt.wantLast {
+				t.Errorf("last call after %v, want %v", last, tt.wantLast)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		tooMany    int
+		retryAfter int
+		wantCalls  int
+		wantErr    bool
+		wantLast   time.Duration
+	}{
+		{name: "retried after the wait", tooMany: 1, retryAfter: 5, wantCalls: 2, wantLast: 5 * time.Second},
+		{name: "gives up after the retries", tooMany: 10, retryAfter: 1, wantCalls: 2, wantErr: true, wantLast: time.Second},
+		{name: "wait too long", tooMany: 1, retryAfter: 120, wantCalls: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &fakeAPI{clock: clock.NewFake(start), tooMany: tt.tooMany, retryAfter: tt.retryAfter}
+			c := newTestClient(api, DefaultLimits)
+
+			_, err := c.Call("sendMessage", map[string]interface{}{"chat_id": int64(-100)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+			if len(api.calls) != tt.wantCalls {
+				t.Fatalf("calls = %d, want %d", len(api.calls), tt.wantCalls)
+			}
+			if last := api.calls[len(api.calls)-1].Sub(start); last != tt.wantLast {
+				t.Errorf("last call after %v, want %v", last, tt.wantLast)
+			}
+		})
+	}
+}
+
+func TestUrgentCallsGoFirst(t *testing.T) {
+	api := &fakeAPI{clock: clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))}
+	c := newTestClient(api, DefaultLimits)
+
+	// an urgent call is waiting, the report has to let it through
+	c.urgent = 1
+	c.sleep = func(d time.Duration) {
+		api.clock.Advance(d)
+		c.mu.Lock()
+		c.urgent = 0
+		c.mu.Unlock()
+	}
+
+	if _, err := c.Call("sendMessage", map[string]interface{}{"chat_id": int64(-100)}); err != nil {
+		t.Fatal(err)
+	}
+	if waited := api.calls[0].Sub(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)); waited == 0 {
+		t.Error("the report didn't wait for the urgent call")
+	}
+}
+
+func TestRetryAfterScope(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		method      string
+		chatId      int64
+		otherChatId int64
+		wantOther   time.Duration
+	}{
+		{name: "delete in a group", method: "deleteMessage", chatId: -100, otherChatId: -200, wantOther: 0},
+		{name: "restrict in the same group", method: "restrictChatMember", chatId: -100, otherChatId: -100, wantOther: 5 * time.Second},
+		{name: "private chat", method: "sendMessage", chatId: 42, otherChatId: 43, wantOther: 0},
+		{name: "no chat pauses everything", method: "getMe", otherChatId: -200, wantOther: 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{}
+			if tt.chatId != 0 {
+				params["chat_id"] = tt.chatId
+			}
+
+			// the call hit by the 429 is retried after retry_after
+			api := &fakeAPI{clock: clock.NewFake(start), tooMany: 1, retryAfter: 5}
+			if _, err := newTestClient(api, DefaultLimits).Call(tt.method, params); err != nil {
+				t.Fatal(err)
+			}
+			if len(api.calls) != 2 {
+				t.Fatalf("calls = %d, want 2", len(api.calls))
+			}
+			if retry := api.calls[1].Sub(start); retry != 5*time.Second {
+				t.Errorf("retried after %v, want 5s", retry)
+			}
+
+			// other calls wait only when they are in the paused scope
+			api = &fakeAPI{clock: clock.NewFake(start)}
+			c := newTestClient(api, DefaultLimits)
+			c.pause(tt.chatId, 5*time.Second)
+			if _, err := c.Call("deleteMessage", map[string]interface{}{"chat_id": tt.otherChatId}); err != nil {
+				t.Fatal(err)
+			}
+			if other := api.calls[0].Sub(start); other != tt.wantOther {
+				t.Errorf("other call after %v, want %v", other, tt.wantOther)
+			}
+		})
+	}
+}
+
+func TestUrgentCallOnPausedChatDoesNotHoldBackOthers(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	api := &fakeAPI{clock: clock.NewFake(start)}
+	c := newTestClient(api, DefaultLimits)
+	c.pause(-100, 2*time.Second)
+
+	// while the urgent delete waits for the pause of its chat, a call to another chat goes through
+	var otherAt time.Time
+	nested, inOther := false, false
+	c.sleep = func(d time.Duration) {
+		if inOther {
+			// the other call yields to the blocked delete, let it through so the test ends
+			t.Error("call to another chat yielded to the delete waiting for its paused chat")
+			c.mu.Lock()
+			c.urgent = 0
+			c.mu.Unlock()
+		}
+		if !nested {
+			nested, inOther = true, true
+			if _, err := c.Call("getChatMember", map[string]interface{}{"chat_id": int64(-200)}); err != nil {
+				t.Error(err)
+			}
+			inOther = false
+			otherAt = api.calls[len(api.calls)-1]
+		}
+		api.clock.Advance(d)
+	}
+
+	if _, err := c.Call("deleteMessage", map[string]interface{}{"chat_id": int64(-100)}); err != nil {
+		t.Fatal(err)
+	}
+	if !otherAt.Equal(start) {
+		t.Errorf("other chat waited %v", otherAt.Sub(start))
+	}
+	if deleted := api.calls[len(api.calls)-1].Sub(start); deleted != 2*time.Second {
+		t.Errorf("delete after %v, want 2s", deleted)
+	}
+}
+
+func TestLongRetryAfterIsCapped(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	api := &fakeAPI{clock: clock.NewFake(start), tooMany: 1, retryAfter: 300}
+	c := newTestClient(api, DefaultLimits)
+
+	// the call gives up right away and leaves the wait to the caller
+	if _, err := c.Call("deleteMessage", map[string]interface{}{"chat_id": int64(-100)}); !telegram.Temporary(err) {
+		t.Fatalf("err = %v, want a temporary error", err)
+	}
+	if waited := api.clock.Now().Sub(start); waited != 0 {
+		t.Errorf("call waited %v", waited)
+	}
+
+	if _, err := c.Call("deleteMessage", map[string]interface{}{"chat_id": int64(-100)}); err != nil {
+		t.Fatal(err)
+	}
+	if next := api.calls[1].Sub(start); next != DefaultLimits.MaxRetryAfter {
+		t.Errorf("next call after %v, want the capped %v", next, DefaultLimits.MaxRetryAfter)
+	}
+}
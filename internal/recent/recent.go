@@ -0,0 +1,210 @@
+// internal/recent/recent.go
+
+// Package recent remembers the latest messages of every user in every chat, so they
+// can be purged together.
+package recent
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// MaxAge is how long messages are remembered, bots can't delete older messages in groups
+const MaxAge = 48 * time.Hour
+
+type key struct {
+	chatID int64
+	userID int64
+}
+
+type message struct {
+	id int64
+	// album is the media group of the message, empty for single messages
+	album string
+	at    time.Time
+}
+
+// user holds the messages of a user in a chat, oldest first.
+type user struct {
+	key      key
+	messages []message
+}
+
+// Messages keeps up to perUser message ids of each user of each chat in memory, and up to
+// total ids altogether. Over the total, the oldest messages of the users who wrote least
+// recently go first.
+type Messages struct {
+	mu      sync.Mutex
+	perUser int
+	total   int
+	count   int
+	byUser  map[key]*list.Element
+	// order holds the users, the one who wrote last in front
+	order *list.List
+}
+
+func NewMessages(perUser int, total int) *Messages {
+	return &Messages{perUser: perUser, total: total, byUser: map[key]*list.Element{}, order: list.New()}
+}
+
+// Add remembers a message and its media group, if any. The oldest message of the user
+// goes when the limit is reached.
+func (r *Messages) Add(chatId int64, userId int64, messageId int64, album string, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := key{chatID: chatId, userID: userId}
+	element, ok := r.byUser[k]
+	if ok {
+		r.order.MoveToFront(element)
+	} else {
+		element = r.order.PushFront(&user{key: k})
+		r.byUser[k] = element
+	}
+
+	messages := append(element.Value.(*user).messages, message{id: messageId, album: album, at: at})
+	if len(messages) > r.perUser {
+		messages = messages[len(messages)-r.perUser:]
+	}
+	r.set(element, messages)
+
+	for r.count > r.total {
+		oldest := r.order.Back()
+		messages := oldest.Value.(*user).messages
+		r.set(oldest, messages[min(r.count-r.total, len(messages)):])
+	}
+}
+
+// Take forgets the messages of the user sent at or after since and returns their ids.
+func (r *Messages) Take(chatId int64, userId int64, since time.Time) []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make([]int64, 0)
+	element, ok := r.byUser[key{chatID: chatId, userID: userId}]
+	if !ok {
+		return ids
+	}
+
+	kept := make([]message, 0)
+	for _, m := range element.Value.(*user).messages {
+		if m.at.Before(since) {
+			kept = append(kept, m)
+		} else {
+			ids = append(ids, m.id)
+		}
+	}
+	r.set(element, kept)
+
+	return ids
+}
+
+// Since returns the ids of the messages of the user sent at or after since, without forgetting them.
+func (r *Messages) Since(chatId int64, userId int64, since time.Time) []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make([]int64, 0)
+	for _, m := range r.messages(chatId, userId) {
+		if !m.at.Before(since) {
+			ids = append(ids, m.id)
+		}
+	}
+
+	return ids
+}
+
+// Album returns the ids of the remembered messages of the user in the media group.
+func (r *Messages) Album(chatId int64, userId int64, album string) []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make([]int64, 0)
+	if album == "" {
+		return ids
+	}
+	for _, m := range r.messages(chatId, userId) {
+		if m.album == album {
+			ids = append(ids, m.id)
+		}
+	}
+
+	return ids
+}
+
+// Forget drops the given messages of the user, like the ones already deleted.
+func (r *Messages) Forget(chatId int64, userId int64, messageIds ...int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	element, ok := r.byUser[key{chatID: chatId, userID: userId}]
+	if !ok {
+		return
+	}
+
+	forget := map[int64]bool{}
+	for _, id := range messageIds {
+		forget[id] = true
+	}
+
+	messages := element.Value.(*user).messages
+	kept := make([]message, 0, len(messages))
+	for _, m := range messages {
+		if !forget[m.id] {
+			kept = append(kept, m)
+		}
+	}
+	r.set(element, kept)
+}
+
+// Prune forgets the messages older than MaxAge.
+func (r *Messages) Prune(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for element := r.order.Front(); element != nil; {
+		next := element.Next()
+
+		messages := element.Value.(*user).messages
+		kept := make([]message, 0, len(messages))
+		for _, m := range messages {
+			if now.Sub(m.at) < MaxAge {
+				kept = append(kept, m)
+			}
+		}
+		r.set(element, kept)
+
+		element = next
+	}
+}
+
+// Len is the number of remembered messages.
+func (r *Messages) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.count
+}
+
+func (r *Messages) messages(chatId int64, userId int64) []message {
+	element, ok := r.byUser[key{chatID: chatId, userID: userId}]
+	if !ok {
+		return nil
+	}
+
+	return element.Value.(*user).messages
+}
+
+// set replaces the messages of a user and drops the user without messages.
+func (r *Messages) set(element *list.Element, messages []message) {
+	u := element.Value.(*user)
+	r.count += len(messages) - len(u.messages)
+
+	if len(messages) == 0 {
+		r.order.Remove(element)
+		delete(r.byUser, u.key)
+		return
+	}
+	u.messages = messages
+}
@@ -0,0 +1,109 @@
+// internal/recent/recent_test.go
+
+package recent
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// add is a message of a user in chat -100, sent i minutes after start
+type add struct {
+	user int64
+	id   int64
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		perUser int
+		total   int
+		adds    []add
+		// want are the remembered ids per user
+		want map[int64][]int64
+	}{
+		{
+			name:    "per user limit drops the oldest",
+			perUser: 2,
+			total:   10,
+			adds:    []add{{1, 1}, {1, 2}, {1, 3}, {2, 4}},
+			want:    map[int64][]int64{1: {2, 3}, 2: {4}},
+		},
+		{
+			name:    "total limit drops the least recent user first",
+			perUser: 10,
+			total:   3,
+			adds:    []add{{1, 1}, {1, 2}, {2, 3}, {3, 4}},
+			want:    map[int64][]int64{1: {2}, 2: {3}, 3: {4}},
+		},
+		{
+			name:    "writing again keeps a user",
+			perUser: 10,
+			total:   3,
+			adds:    []add{{1, 1}, {2, 2}, {3, 3}, {1, 4}, {4, 5}},
+			want:    map[int64][]int64{1: {1, 4}, 2: {}, 3: {}, 4: {5}},
+		},
+		{
+			name:    "total limit empties a user",
+			perUser: 10,
+			total:   2,
+			adds:    []add{{1, 1}, {2, 2}, {2, 3}},
+			want:    map[int64][]int64{1: {}, 2: {2, 3}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewMessages(tt.perUser, tt.total)
+			for i, a := range tt.adds {
+				r.Add(-100, a.user, a.id, "", start.Add(time.Duration(i)*time.Minute))
+			}
+
+			count := 0
+			for user, want := range tt.want {
+				if got := r.Since(-100, user, time.Time{}); !reflect.DeepEqual(got, want) {
+					t.Errorf("user %d: remembered %v, want %v", user, got, want)
+				}
+				count += len(want)
+			}
+			if r.Len() != count {
+				t.Errorf("len = %d, want %d", r.Len(), count)
+			}
+		})
+	}
+}
+
+func TestTakeForgetPrune(t *testing.T) {
+	r := NewMessages(10, 10)
+	r.Add(-100, 1, 1, "album", start)
+	r.Add(-100, 1, 2, "album", start.Add(time.Hour))
+	r.Add(-100, 1, 3, "", start.Add(2*time.Hour))
+	r.Add(-100, 2, 4, "", start.Add(3*time.Hour))
+
+	if got := r.Album(-100, 1, "album"); !reflect.DeepEqual(got, []int64{1, 2}) {
+		t.Errorf("album = %v", got)
+	}
+	if got := r.Take(-100, 1, start.Add(time.Hour)); !reflect.DeepEqual(got, []int64{2, 3}) {
+		t.Errorf("taken = %v", got)
+	}
+	r.Forget(-100, 2, 4)
+	if r.Len() != 1 {
+		t.Errorf("len after take and forget = %d, want 1", r.Len())
+	}
+
+	r.Prune(start.Add(MaxAge))
+	if r.Len() != 0 || len(r.Since(-100, 1, time.Time{})) != 0 {
+		t.Errorf("pruned store still holds %d message(s)", r.Len())
+	}
+
+	// the users dropped by the prune don't count towards the total
+	for i := int64(0); i < 10; i++ {
+		r.Add(-100, 3, 10+i, "", start.Add(MaxAge))
+	}
+	if got := r.Since(-100, 3, time.Time{}); len(got) != 10 {
+		t.Errorf("remembered %v after the prune, want 10", got)
+	}
+}
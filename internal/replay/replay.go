@@ -0,0 +1,315 @@
+// internal/replay/replay.go
+
+// Package replay runs recorded updates through the moderation against a client that
+// only records the Bot API calls, so rule changes can be checked against history.
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"telegram_moderator/internal/clock"
+	"telegram_moderator/internal/config"
+	"telegram_moderator/internal/i18n"
+	"telegram_moderator/internal/logging"
+	"telegram_moderator/internal/moderator"
+	"telegram_moderator/internal/storage"
+	"telegram_moderator/pkg/models"
+	"time"
+)
+
+// logPrefix is how the webhook handler used to log the bodies as text
+const logPrefix = "Request body: "
+
+// Config holds the options of a replay.
+type Config struct {
+	Settings *config.ChatSettingsFile
+	// TLDs are fetched from the network when nil
+	TLDs map[string]string
+	// Members are the user ids getChatMember reports as members, everybody else has left
+	Members map[int64]bool
+	// Seed makes the verification questions repeatable
+	Seed int64
+}
+
+type call struct {
+	method string
+	params map[string]interface{}
+}
+
+// replayBot is the bot getMe reports, an administrator with every right in all chats.
+var replayBot = models.User{ID: 1, IsBot: true, FirstName: "Replay", Username: "replay_bot"}
+
+// recorder is the Bot API client of a replay, it answers like Telegram without sending anything.
+type recorder struct {
+	mu            sync.Mutex
+	calls         []call
+	members       map[int64]bool
+	nextMessageID int64
+}
+
+func (r *recorder) Call(method string, params interface{}) (json.RawMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := call{method: method, params: map[string]interface{}{}}
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.UseNumber()
+	decoder.Decode(&c.params)
+	r.calls = append(r.calls, c)
+
+	switch method {
+	case "getMe":
+		return json.Marshal(replayBot)
+	case "getChatMember":
+		if paramInt(c.params, "user_id") == replayBot.ID {
+			return json.Marshal(models.ChatMember{User: replayBot, Status: "administrator", CanDeleteMessages: true, CanRestrictMembers: true, CanPinMessages: true})
+		}
+		status := "left"
+		if r.members[paramInt(c.params, "user_id")] {
+			status = "member"
+		}
+		return json.Marshal(models.ChatMember{Status: status})
+	case "sendMessage", "copyMessage":
+		r.nextMessageID++
+		return json.Marshal(models.Message{MessageID: r.nextMessageID})
+	}
+
+	return json.RawMessage("true"), nil
+}
+
+// take returns the calls recorded since the last take.
+func (r *recorder) take() []call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	calls := r.calls
+	r.calls = nil
+	return calls
+}
+
+func paramInt(params map[string]interface{}, key string) int64 {
+	if n, ok := params[key].(json.Number); ok {
+		v, _ := n.Int64()
+		return v
+	}
+
+	return 0
+}
+
+// decision describes a call as the moderation decision behind it. chatId is the chat of
+// the update, messages to any other chat go to the moderation log.
+func decision(c call, chatId int64) (string, bool) {
+	switch c.method {
+	case "getMe", "getChatMember", "answerCallbackQuery", "editMessageText", "editMessageReplyMarkup":
+		return "", false
+	case "sendMessage":
+		if c.params["parse_mode"] == "HTML" {
+			return "report", true
+		}
+		if paramInt(c.params, "chat_id") != chatId {
+			return "log", true
+		}
+		if _, ok := c.params["reply_markup"]; ok {
+			return "challenge", true
+		}
+		return "reply", true
+	case "deleteMessage":
+		return fmt.Sprintf("delete %d", paramInt(c.params, "message_id")), true
+	case "deleteMessages":
+		ids, _ := c.params["message_ids"].([]interface{})
+		deletes := make([]string, 0, len(ids))
+		for _, id := range ids {
+			deletes = append(deletes, fmt.Sprintf("delete %v", id))
+		}
+		return strings.Join(deletes, ", "), len(deletes) > 0
+	case "restrictChatMember":
+		return "mute", true
+	case "banChatMember":
+		if paramInt(c.params, "until_date") != 0 {
+			return "kick", true
+		}
+		return "ban", true
+	case "unbanChatMember":
+		return "unban", true
+	case "copyMessage":
+		return "log evidence", true
+	}
+
+	return c.method, true
+}
+
+// noDecision describes an update the bot did nothing about
+const noDecision = "none"
+
+func decisions(calls []call, chatId int64) string {
+	names := make([]string, 0)
+	for _, c := range calls {
+		if name, ok := decision(c, chatId); ok {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return noDecision
+	}
+	return strings.Join(names, ", ")
+}
+
+// parseLine reads an update from a JSONL line: the raw body, the debug line of the JSON log
+// holding it or a line of the older text log.
+func parseLine(line string) (models.Update, bool, error) {
+	var update models.Update
+
+	if i := strings.Index(line, logPrefix); i >= 0 {
+		line = line[i+len(logPrefix):]
+	}
+	line = strings.TrimSpace(line)
+	if line == "" || !strings.HasPrefix(line, "{") {
+		return update, false, nil
+	}
+
+	var logLine struct {
+		Msg  string          `json:"msg"`
+		Body json.RawMessage `json:"body"`
+	}
+	if err := json.Unmarshal([]byte(line), &logLine); err != nil {
+		return update, false, err
+	}
+	if logLine.Msg != "" {
+		if logLine.Msg != logging.UpdateReceived || !strings.HasPrefix(string(logLine.Body), "{") {
+			return update, false, nil
+		}
+		line = string(logLine.Body)
+	}
+
+	if err := json.Unmarshal([]byte(line), &update); err != nil {
+		return update, false, err
+	}
+
+	return update, true, nil
+}
+
+func updateChatID(update models.Update) int64 {
+	if update.Message != nil {
+		return update.Message.Chat.ID
+	}
+	if update.CallbackQuery != nil && update.CallbackQuery.Message != nil {
+		return update.CallbackQuery.Message.Chat.ID
+	}
+
+	return 0
+}
+
+func describe(update models.Update) string {
+	if update.Message != nil {
+		return fmt.Sprintf("update %d message %d chat %d user %d", update.UpdateID, update.Message.MessageID, update.Message.Chat.ID, update.Message.From.ID)
+	}
+	if update.CallbackQuery != nil && update.CallbackQuery.Message != nil {
+		return fmt.Sprintf("update %d callback chat %d user %d data %q", update.UpdateID, update.CallbackQuery.Message.Chat.ID, update.CallbackQuery.From.ID, update.CallbackQuery.Data)
+	}
+
+	return fmt.Sprintf("update %d", update.UpdateID)
+}
+
+// Run replays the updates of in and prints the decisions for each of them to out.
+// The clock follows the message dates, so unanswered questions time out between updates.
+func Run(in io.Reader, out io.Writer, cfg Config) error {
+	dir, err := os.MkdirTemp("", "replay")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := storage.NewStore(dir)
+	if err != nil {
+		return err
+	}
+	catalog, err := i18n.Load()
+	if err != nil {
+		return err
+	}
+
+	// the replay never starts the jobs of the moderator, so it loads the list itself
+	tlds := cfg.TLDs
+	if tlds == nil {
+		if tlds, err = moderator.FetchTLDs(moderator.DefaultTLDURL); err != nil {
+			return fmt.Errorf("fetching TLDs: %v", err)
+		}
+	}
+
+	client := &recorder{members: cfg.Members}
+	clk := clock.NewFake(time.Time{})
+	mod, err := moderator.New(moderator.Config{
+		Client:          client,
+		Clock:           clk,
+		Random:          rand.New(rand.NewSource(cfg.Seed)),
+		Store:           store,
+		Settings:        cfg.Settings,
+		Catalog:         catalog,
+		TLDs:            tlds,
+		VerifiedUserTTL: 30 * 24 * time.Hour,
+		AppealWindow:    7 * 24 * time.Hour,
+	})
+	if err != nil {
+		return err
+	}
+
+	// advance moves the clock and prints what the due jobs did
+	advance := func(to time.Time) {
+		if !to.After(clk.Now()) {
+			return
+		}
+		if clk.Now().IsZero() {
+			clk.Set(to)
+			return
+		}
+		clk.Set(to)
+		mod.RunDueJobs()
+		// jobs that only read, like the permission audit, aren't decisions
+		if names := decisions(client.take(), 0); names != noDecision {
+			fmt.Fprintf(out, "jobs at %s: %s\n", to.UTC().Format(time.RFC3339), names)
+		}
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		update, ok, err := parseLine(scanner.Text())
+		if err != nil {
+			fmt.Fprintf(out, "line %d: skipped, %v\n", lineNumber, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		if update.Message != nil && update.Message.Date != 0 {
+			advance(time.Unix(update.Message.Date, 0))
+		}
+
+		mod.HandleUpdate(logging.WithAttrs(context.Background(), logging.UpdateAttrs(update)...), update)
+		fmt.Fprintf(out, "%s: %s\n", describe(update), decisions(client.take(), updateChatID(update)))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// let the last questions time out
+	advance(clk.Now().Add(time.Hour))
+
+	return nil
+}
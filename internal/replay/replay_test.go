@@ -0,0 +1,49 @@
+// internal/replay/replay_test.go
+
+package replay
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"telegram_moderator/internal/config"
+	"testing"
+)
+
+const updates = `{"update_id":1,"message":{"message_id":10,"from":{"id":42,"first_name":"Spammer"},"chat":{"id":-100,"type":"supergroup"},"date":1700000000,"text":"visit example.com"}}
+2024/01/01 12:00:05 Request body: {"update_id":2,"message":{"message_id":11,"from":{"id":7,"first_name":"Member"},"chat":{"id":-100,"type":"supergroup"},"date":1700000005,"text":"see example.com"}}
+not an update
+{"time":"2023-11-14T22:14:00Z","level":"INFO","msg":"Update handled","update_id":2}
+{"time":"2023-11-14T22:14:10Z","level":"DEBUG","msg":"Update received","update_id":4,"body":{"update_id":4,"message":{"message_id":13,"from":{"id":7,"first_name":"Member"},"chat":{"id":-100,"type":"supergroup"},"date":1700000010,"text":"hi"}}}
+{"update_id":3,"message":{"message_id":12,"from":{"id":42,"first_name":"Spammer"},"chat":{"id":-100,"type":"supergroup"},"date":1700000100,"text":"hello"}}
+`
+
+func TestRun(t *testing.T) {
+	settings, err := config.LoadChatSettings(filepath.Join(t.TempDir(), "chats.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = Run(strings.NewReader(updates), &out, Config{
+		Settings: settings,
+		TLDs:     map[string]string{"com": "Commercial"},
+		Members:  map[int64]bool{7: true},
+		Seed:     1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"update 1 message 10 chat -100 user 42: challenge",
+		"update 2 message 11 chat -100 user 7: none",
+		"update 4 message 13 chat -100 user 7: none",
+		"jobs at 2023-11-14T22:15:00Z: delete 1, delete 10, report",
+		"update 3 message 12 chat -100 user 42: none",
+	}
+	got := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("output:\n%s\nwant:\n%s", out.String(), strings.Join(want, "\n"))
+	}
+}
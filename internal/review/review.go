@@ -0,0 +1,100 @@
+// internal/review/review.go
+
+package review
+
+import (
+	"fmt"
+	"sync"
+	"telegram_moderator/internal/storage"
+	"time"
+)
+
+const storeName = "review_queue"
+
+// Item is a message of a user who failed the verification, held until an admin decides on it.
+type Item struct {
+	ID            string    `json:"id"`
+	ChatID        int64     `json:"chat_id"`
+	ChatTitle     string    `json:"chat_title"`
+	UserID        int64     `json:"user_id"`
+	Username      string    `json:"username"`
+	FirstName     string    `json:"first_name"`
+	LanguageCode  string    `json:"language_code"`
+	MessageID     int64     `json:"message_id"`
+	PostMessageID int64     `json:"post_message_id"`
+	Text          string    `json:"text"`
+	URLs          []string  `json:"urls"`
+	Reason        string    `json:"reason"`
+	Date          time.Time `json:"date"`
+	QueuedAt      time.Time `json:"queued_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	LogChatID     int64     `json:"log_chat_id"`
+	LogMessageID  int64     `json:"log_message_id"`
+}
+
+func ItemID(chatId int64, messageId int64) string {
+	return fmt.Sprintf("%d_%d", chatId, messageId)
+}
+
+// Queue is the persisted list of items waiting for review.
+type Queue struct {
+	mu    sync.Mutex
+	store *storage.Store
+	items map[string]Item
+}
+
+func NewQueue(store *storage.Store) (*Queue, error) {
+	q := &Queue{
+		store: store,
+		items: map[string]Item{},
+	}
+
+	if err := store.Load(storeName, &q.items); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+func (q *Queue) Add(item Item) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.items[item.ID] = item
+	return q.store.Save(storeName, q.items)
+}
+
+// Take removes the item from the queue so only one decision is ever applied to it.
+func (q *Queue) Take(id string) (Item, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item, ok := q.items[id]
+	if !ok {
+		return item, false, nil
+	}
+
+	delete(q.items, id)
+	return item, true, q.store.Save(storeName, q.items)
+}
+
+// All returns every waiting item.
+func (q *Queue) All() []Item {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := make([]Item, 0, len(q.items))
+	for _, item := range q.items {
+		items = append(items, item)
+	}
+
+	return items
+}
+
+func (q *Queue) Get(id string) (Item, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item, ok := q.items[id]
+	return item, ok
+}
@@ -0,0 +1,352 @@
+// internal/scheduler/scheduler.go
+
+package scheduler
+
+import (
+	"container/heap"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"telegram_moderator/internal/clock"
+	"telegram_moderator/internal/storage"
+	"time"
+)
+
+const storeName = "scheduler"
+
+// idleWait bounds the sleep of the loop so a jump of the wall clock is noticed eventually
+const idleWait = time.Minute
+
+// saveDelay coalesces the writes of the job file, a burst of changes is saved once
+const saveDelay = time.Second
+
+// Job is a unit of delayed work. Jobs with the same key replace each other.
+type Job struct {
+	Key     string          `json:"key"`
+	Kind    string          `json:"kind"`
+	RunAt   time.Time       `json:"run_at"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// Every repeats the job with this interval. Repeating jobs are registered on every
+	// start, so they are not persisted.
+	Every time.Duration `json:"-"`
+
+	index int
+}
+
+// Decode unmarshals the payload of the job into v.
+func (j Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// Handler runs a job of one kind.
+type Handler func(job Job)
+
+type jobHeap []*Job
+
+func (h jobHeap) Len() int           { return len(h) }
+func (h jobHeap) Less(i, j int) bool { return h[i].RunAt.Before(h[j].RunAt) }
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *jobHeap) Push(x interface{}) {
+	job := x.(*Job)
+	job.index = len(*h)
+	*h = append(*h, job)
+}
+
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	job := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	job.index = -1
+	return job
+}
+
+// Scheduler runs jobs at their time from a single min-heap. One-off jobs are persisted,
+// so they still run after a restart, late if the bot was down at their time.
+// Every due job runs in its own goroutine, so a slow one doesn't hold back the others.
+type Scheduler struct {
+	mu       sync.Mutex
+	store    *storage.Store
+	clock    clock.Clock
+	jobs     jobHeap
+	byKey    map[string]*Job
+	handlers map[string]Handler
+	wake     chan struct{}
+	// running holds the keys of the repeating jobs still running, they are skipped until done
+	running map[string]bool
+
+	// dirty is set when the one-off jobs changed since the last write, saveTimer writes them
+	dirty     bool
+	saveTimer *time.Timer
+}
+
+func New(store *storage.Store, clk clock.Clock) (*Scheduler, error) {
+	s := &Scheduler{
+		store:    store,
+		clock:    clk,
+		byKey:    map[string]*Job{},
+		handlers: map[string]Handler{},
+		wake:     make(chan struct{}, 1),
+		running:  map[string]bool{},
+	}
+
+	var saved []*Job
+	if err := store.Load(storeName, &saved); err != nil {
+		return nil, err
+	}
+	for _, job := range saved {
+		s.byKey[job.Key] = job
+		heap.Push(&s.jobs, job)
+	}
+
+	return s, nil
+}
+
+// Handle registers the handler of a job kind. Jobs of kinds without a handler are dropped when due.
+func (s *Scheduler) Handle(kind string, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers[kind] = handler
+}
+
+// Schedule runs a one-off job at runAt, replacing a job with the same key.
+func (s *Scheduler) Schedule(key string, kind string, runAt time.Time, payload interface{}) error {
+	var raw json.RawMessage
+	if payload != nil {
+		var err error
+		if raw, err = json.Marshal(payload); err != nil {
+			return err
+		}
+	}
+
+	s.add(&Job{Key: key, Kind: kind, RunAt: runAt, Payload: raw})
+	return nil
+}
+
+// Every runs the job every interval, first after one interval.
+func (s *Scheduler) Every(key string, kind string, interval time.Duration) {
+	s.add(&Job{Key: key, Kind: kind, RunAt: s.clock.Now().Add(interval), Every: interval})
+}
+
+func (s *Scheduler) add(job *Job) {
+	s.mu.Lock()
+	old, replaced := s.byKey[job.Key]
+	if replaced {
+		heap.Remove(&s.jobs, old.index)
+	}
+	s.byKey[job.Key] = job
+	heap.Push(&s.jobs, job)
+	if job.Every == 0 || (replaced && old.Every == 0) {
+		s.changedLocked()
+	}
+	s.mu.Unlock()
+
+	s.notify()
+}
+
+// Cancel removes the job and reports whether it was still waiting.
+func (s *Scheduler) Cancel(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.byKey[key]
+	if !ok {
+		return false
+	}
+
+	heap.Remove(&s.jobs, job.index)
+	delete(s.byKey, key)
+	if job.Every == 0 {
+		s.changedLocked()
+	}
+
+	return true
+}
+
+// Jobs returns the waiting jobs of the kind.
+func (s *Scheduler) Jobs(kind string) []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]Job, 0)
+	for _, job := range s.jobs {
+		if job.Kind == kind {
+			jobs = append(jobs, *job)
+		}
+	}
+
+	return jobs
+}
+
+// Len is the number of waiting jobs.
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.jobs)
+}
+
+// changedLocked marks the one-off jobs as changed, they are written within saveDelay.
+func (s *Scheduler) changedLocked() {
+	s.dirty = true
+	if s.saveTimer == nil {
+		s.saveTimer = time.AfterFunc(saveDelay, func() {
+			if err := s.Flush(); err != nil {
+				slog.Error("Error saving scheduled jobs", "error", err)
+			}
+		})
+	}
+}
+
+// Flush writes the one-off jobs if they changed since the last write. A failed write
+// is tried again after saveDelay.
+func (s *Scheduler) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.saveTimer != nil {
+		s.saveTimer.Stop()
+		s.saveTimer = nil
+	}
+	if !s.dirty {
+		return nil
+	}
+
+	if err := s.saveLocked(); err != nil {
+		s.changedLocked()
+		return err
+	}
+	s.dirty = false
+
+	return nil
+}
+
+func (s *Scheduler) saveLocked() error {
+	durable := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		if job.Every == 0 {
+			durable = append(durable, job)
+		}
+	}
+
+	return s.store.Save(storeName, durable)
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// RunDue runs every job that is due at the current time of the clock and waits for them.
+// It lets a fake clock drive the jobs, Run doesn't wait.
+func (s *Scheduler) RunDue() {
+	s.dispatch().Wait()
+}
+
+// dispatch starts every due job in its own goroutine. The returned group is done when they finished.
+func (s *Scheduler) dispatch() *sync.WaitGroup {
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	due := make([]*Job, 0)
+	for len(s.jobs) > 0 && !s.jobs[0].RunAt.After(now) {
+		job := heap.Pop(&s.jobs).(*Job)
+		if job.Every > 0 {
+			// keep the job registered under its key while it runs, so it can be cancelled
+			next := *job
+			next.RunAt = now.Add(job.Every)
+			s.byKey[job.Key] = &next
+			heap.Push(&s.jobs, &next)
+			if s.running[job.Key] {
+				slog.Warn("Repeating job still running, skipping this run", "key", job.Key, "kind", job.Kind)
+				continue
+			}
+			s.running[job.Key] = true
+		} else {
+			delete(s.byKey, job.Key)
+			s.changedLocked()
+		}
+		due = append(due, job)
+	}
+	handlers := make([]Handler, len(due))
+	for i, job := range due {
+		handlers[i] = s.handlers[job.Kind]
+	}
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i, job := range due {
+		handler := handlers[i]
+		if handler == nil {
+			slog.Warn("No handler for scheduled job, dropping it", "key", job.Key, "kind", job.Kind)
+			s.done(*job)
+			continue
+		}
+
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			defer s.done(job)
+			handler(job)
+		}(*job)
+	}
+
+	return &wg
+}
+
+// done lets a repeating job run again.
+func (s *Scheduler) done(job Job) {
+	if job.Every == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.running, job.Key)
+}
+
+// nextWait is how long the loop may sleep before the next job is due.
+func (s *Scheduler) nextWait() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.jobs) == 0 {
+		return idleWait
+	}
+
+	wait := s.jobs[0].RunAt.Sub(s.clock.Now())
+	if wait > idleWait {
+		return idleWait
+	}
+
+	return wait
+}
+
+// Run starts jobs as they become due until stop is closed, then writes the pending changes.
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	for {
+		s.dispatch()
+
+		timer := time.NewTimer(s.nextWait())
+		select {
+		case <-stop:
+			timer.Stop()
+			if err := s.Flush(); err != nil {
+				slog.Error("Error saving scheduled jobs", "error", err)
+			}
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
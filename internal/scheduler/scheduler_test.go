@@ -0,0 +1,210 @@
+// internal/scheduler/scheduler_test.go
+
+package scheduler
+
+import (
+	"reflect"
+	"sync"
+	"telegram_moderator/internal/clock"
+	"telegram_moderator/internal/storage"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestScheduler(t *testing.T, dir string, clk clock.Clock) *Scheduler {
+	t.Helper()
+
+	store, err := storage.NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(store, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// recorder collects the keys of the jobs that ran.
+type recorder struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (r *recorder) handle(job Job) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.keys = append(r.keys, job.Key)
+}
+
+func (r *recorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := r.keys
+	r.keys = nil
+	return keys
+}
+
+func TestOrderAndCancel(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, s *Scheduler)
+		advance []time.Duration
+		want    [][]string
+	}{
+		{
+			name: "jobs run at their time in order",
+			setup: func(t *testing.T, s *Scheduler) {
+				s.Schedule("c", "test", start.Add(3*time.Second), nil)
+				s.Schedule("a", "test", start.Add(time.Second), nil)
+				s.Schedule("b", "test", start.Add(2*time.Second), nil)
+			},
+			advance: []time.Duration{0, time.Second, time.Second, time.Second},
+			want:    [][]string{nil, {"a"}, {"b"}, {"c"}},
+		},
+		{
+			name: "late jobs run on the next tick",
+			setup: func(t *testing.T, s *Scheduler) {
+				s.Schedule("a", "test", start.Add(time.Second), nil)
+			},
+			advance: []time.Duration{time.Hour, time.Hour},
+			want:    [][]string{{"a"}, nil},
+		},
+		{
+			name: "cancelled job doesn't run",
+			setup: func(t *testing.T, s *Scheduler) {
+				s.Schedule("a", "test", start.Add(time.Second), nil)
+				s.Schedule("b", "test", start.Add(time.Second), nil)
+				if !s.Cancel("a") {
+					t.Error("a was not waiting")
+				}
+				if s.Cancel("missing") {
+					t.Error("missing was waiting")
+				}
+			},
+			advance: []time.Duration{time.Second},
+			want:    [][]string{{"b"}},
+		},
+		{
+			name: "same key replaces the job",
+			setup: func(t *testing.T, s *Scheduler) {
+				s.Schedule("a", "test", start.Add(time.Second), nil)
+				s.Schedule("a", "test", start.Add(time.Hour), nil)
+			},
+			advance: []time.Duration{time.Second, time.Hour},
+			want:    [][]string{nil, {"a"}},
+		},
+		{
+			name: "repeating job",
+			setup: func(t *testing.T, s *Scheduler) {
+				s.Every("a", "test", time.Minute)
+			},
+			advance: []time.Duration{30 * time.Second, 30 * time.Second, time.Minute},
+			want:    [][]string{nil, {"a"}, {"a"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(start)
+			s := newTestScheduler(t, t.TempDir(), clk)
+			r := &recorder{}
+			s.Handle("test", r.handle)
+			tt.setup(t, s)
+
+			for i, advance := range tt.advance {
+				clk.Advance(advance)
+				s.RunDue()
+				if got := r.take(); !reflect.DeepEqual(got, tt.want[i]) {
+					t.Errorf("tick %d: ran %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReloadAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	clk := clock.NewFake(start)
+
+	s := newTestScheduler(t, dir, clk)
+	s.Schedule("kept", "test", start.Add(time.Minute), map[string]int{"n": 1})
+	s.Schedule("cancelled", "test", start.Add(time.Minute), nil)
+	s.Cancel("cancelled")
+	s.Every("repeating", "test", time.Minute)
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newTestScheduler(t, dir, clk)
+	jobs := restarted.Jobs("test")
+	if len(jobs) != 1 || jobs[0].Key != "kept" || !jobs[0].RunAt.Equal(start.Add(time.Minute)) {
+		t.Fatalf("jobs after restart = %v, want only kept", jobs)
+	}
+
+	var payload map[string]int
+	r := &recorder{}
+	restarted.Handle("test", func(job Job) {
+		r.handle(job)
+		if err := job.Decode(&payload); err != nil {
+			t.Error(err)
+		}
+	})
+
+	// the bot was down at the time of the job, it runs late
+	clk.Advance(time.Hour)
+	restarted.RunDue()
+	if got := r.take(); !reflect.DeepEqual(got, []string{"kept"}) {
+		t.Errorf("ran %v, want [kept]", got)
+	}
+	if payload["n"] != 1 {
+		t.Errorf("payload = %v", payload)
+	}
+}
+
+func TestSlowJobDoesNotHoldBackOthers(t *testing.T) {
+	s := newTestScheduler(t, t.TempDir(), clock.Real{})
+
+	release := make(chan struct{})
+	slowStarted := make(chan struct{}, 2)
+	s.Handle("slow", func(job Job) {
+		slowStarted <- struct{}{}
+		<-release
+	})
+	fast := make(chan string, 1)
+	s.Handle("fast", func(job Job) { fast <- job.Key })
+
+	s.Every("slow", "slow", 10*time.Millisecond)
+	s.Schedule("fast", "fast", time.Now().Add(50*time.Millisecond), nil)
+
+	stop := make(chan struct{})
+	go s.Run(stop)
+	defer close(stop)
+	defer close(release)
+
+	select {
+	case <-slowStarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow job didn't start")
+	}
+	select {
+	case key := <-fast:
+		if key != "fast" {
+			t.Errorf("ran %q", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fast job waited for the slow one")
+	}
+
+	// the repeating job doesn't start again while it still runs
+	select {
+	case <-slowStarted:
+		t.Error("slow job started twice")
+	default:
+	}
+}
@@ -0,0 +1,92 @@
+// internal/shadow/shadow.go
+
+package shadow
+
+import (
+	"sync"
+	"telegram_moderator/internal/storage"
+	"time"
+)
+
+const storeName = "shadow_log"
+
+// retention is how long entries are kept for the summary
+const retention = 30 * 24 * time.Hour
+
+// Entry is a message the bot would have acted on in a chat running in shadow mode.
+type Entry struct {
+	ChatID    int64    `json:"chat_id"`
+	MessageID int64    `json:"message_id"`
+	UserID    int64    `json:"user_id"`
+	Username  string   `json:"username"`
+	FirstName string   `json:"first_name"`
+	Text      string   `json:"text"`
+	URLs      []string `json:"urls"`
+	// Penalty is the escalation step the user would have got on top of the deletion
+	Penalty string    `json:"penalty"`
+	Strike  int       `json:"strike"`
+	At      time.Time `json:"at"`
+}
+
+// Log is the persisted list of shadow mode entries.
+type Log struct {
+	mu      sync.Mutex
+	store   *storage.Store
+	entries []Entry
+}
+
+func NewLog(store *storage.Store) (*Log, error) {
+	l := &Log{
+		store:   store,
+		entries: []Entry{},
+	}
+
+	if err := store.Load(storeName, &l.entries); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *Log) Add(entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = append(l.entries, entry)
+	return l.store.Save(storeName, l.entries)
+}
+
+// Since returns the entries of the chat recorded at or after since, oldest first.
+func (l *Log) Since(chatId int64, since time.Time) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := make([]Entry, 0)
+	for _, entry := range l.entries {
+		if entry.ChatID == chatId && !entry.At.Before(since) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// Prune drops the entries older than the retention.
+func (l *Log) Prune(now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	kept := make([]Entry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		if now.Sub(entry.At) < retention {
+			kept = append(kept, entry)
+		}
+	}
+
+	if len(kept) == len(l.entries) {
+		return nil
+	}
+
+	l.entries = kept
+	return l.store.Save(storeName, l.entries)
+}
@@ -0,0 +1,78 @@
+// internal/storage/storage.go
+
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store keeps named JSON documents in a directory on disk.
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Store{dir: dir}, nil
+}
+
+// Load decodes the document into v. A missing document is not an error and leaves v untouched.
+func (s *Store) Load(name string, v interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// Save replaces the document with v. The file is written to a temporary path first
+// so a crash never leaves a truncated document behind.
+func (s *Store) Save(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmpPath := s.path(name) + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, s.path(name))
+}
+
+// CheckWritable writes and removes a probe file, so a full disk or a read only
+// directory shows up before a Save fails.
+func (s *Store) CheckWritable() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	probePath := filepath.Join(s.dir, ".probe")
+	if err := os.WriteFile(probePath, []byte("ok"), 0o644); err != nil {
+		return err
+	}
+
+	return os.Remove(probePath)
+}
+
+func (s *Store) path(name string) string {
+	return filepath.Join(s.dir, name+".json")
+}
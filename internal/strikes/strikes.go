@@ -0,0 +1,84 @@
+// internal/strikes/strikes.go
+
+package strikes
+
+import (
+	"fmt"
+	"sync"
+	"telegram_moderator/internal/storage"
+	"time"
+)
+
+const storeName = "strikes"
+
+type record struct {
+	Count      int       `json:"count"`
+	LastStrike time.Time `json:"last_strike"`
+}
+
+// Counter counts failed verifications per chat member. Strikes decay one by one,
+// each decay period after the last strike forgives one of them.
+type Counter struct {
+	mu      sync.Mutex
+	store   *storage.Store
+	records map[string]record
+}
+
+func NewCounter(store *storage.Store) (*Counter, error) {
+	c := &Counter{
+		store:   store,
+		records: map[string]record{},
+	}
+
+	if err := store.Load(storeName, &c.records); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func key(chatId int64, userId int64) string {
+	return fmt.Sprintf("%d:%d", chatId, userId)
+}
+
+func decayed(r record, decay time.Duration, now time.Time) int {
+	if decay <= 0 || r.Count == 0 {
+		return r.Count
+	}
+
+	forgiven := int(now.Sub(r.LastStrike) / decay)
+	if forgiven >= r.Count {
+		return 0
+	}
+
+	return r.Count - forgiven
+}
+
+// Add records a strike and returns the resulting strike number.
+func (c *Counter) Add(chatId int64, userId int64, decay time.Duration, now time.Time) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := key(chatId, userId)
+	r := c.records[k]
+	r.Count = decayed(r, decay, now) + 1
+	r.LastStrike = now
+	c.records[k] = r
+
+	return r.Count, c.store.Save(storeName, c.records)
+}
+
+func (c *Counter) Get(chatId int64, userId int64, decay time.Duration, now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return decayed(c.records[key(chatId, userId)], decay, now)
+}
+
+func (c *Counter) Reset(chatId int64, userId int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.records, key(chatId, userId))
+	return c.store.Save(storeName, c.records)
+}
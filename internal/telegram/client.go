@@ -0,0 +1,117 @@
+// internal/telegram/client.go
+
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const DefaultBaseURL = "https://api.telegram.org"
+
+// Client calls Bot API methods. params is encoded as the JSON body of the request and
+// the "result" field of a successful response is returned as is.
+type Client interface {
+	Call(method string, params interface{}) (json.RawMessage, error)
+}
+
+// ResponseParameters is the "parameters" field of a failed response.
+type ResponseParameters struct {
+	MigrateToChatID int64 `json:"migrate_to_chat_id"`
+	RetryAfter      int   `json:"retry_after"`
+}
+
+// APIError is a response of the Bot API with "ok": false.
+type APIError struct {
+	Method      string
+	Code        int
+	Description string
+	Parameters  ResponseParameters
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s failed with code %d: %s", e.Method, e.Code, e.Description)
+}
+
+// Temporary reports whether a failed call may succeed when repeated: the request didn't get
+// a response, was rate limited or hit a server error. Other API errors are permanent.
+func Temporary(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return true
+	}
+
+	return apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= http.StatusInternalServerError
+}
+
+type response struct {
+	Ok          bool                `json:"ok"`
+	Result      json.RawMessage     `json:"result"`
+	ErrorCode   int                 `json:"error_code"`
+	Description string              `json:"description"`
+	Parameters  *ResponseParameters `json:"parameters"`
+}
+
+// HTTPClient is the Client talking to the Bot API server over HTTP.
+type HTTPClient struct {
+	token      string
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewHTTPClient returns a client of the server at baseURL, DefaultBaseURL when empty.
+func NewHTTPClient(token string, baseURL string) *HTTPClient {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+
+	return &HTTPClient{
+		token:      token,
+		baseURL:    baseURL,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *HTTPClient) Call(method string, params interface{}) (json.RawMessage, error) {
+	payload, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Post(c.baseURL+"/bot"+c.token+"/"+method, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		// the error of the request repeats the URL, which holds the token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return nil, fmt.Errorf("%s: %v", method, urlErr.Err)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var r response
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("%s returned status %d: %v", method, resp.StatusCode, err)
+	}
+
+	if !r.Ok {
+		apiErr := &APIError{Method: method, Code: r.ErrorCode, Description: r.Description}
+		if r.Parameters != nil {
+			apiErr.Parameters = *r.Parameters
+		}
+		return nil, apiErr
+	}
+
+	return r.Result, nil
+}
@@ -0,0 +1,317 @@
+// internal/telegramtest/server.go
+
+// Package telegramtest runs an in-process fake of the Bot API for integration tests.
+package telegramtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"telegram_moderator/pkg/models"
+	"time"
+)
+
+// Token is the bot token the fake server accepts.
+const Token = "123456:TEST"
+
+// Call is a Bot API request received by the fake server.
+type Call struct {
+	Method string
+	Params map[string]interface{}
+}
+
+// Int returns the integer parameter key, 0 when it is missing.
+func (c Call) Int(key string) int64 {
+	switch v := c.Params[key].(type) {
+	case json.Number:
+		n, _ := v.Int64()
+		return n
+	case string:
+		var n int64
+		fmt.Sscan(v, &n)
+		return n
+	}
+
+	return 0
+}
+
+// Ints returns the numbers of an array parameter.
+func (c Call) Ints(key string) []int64 {
+	values, _ := c.Params[key].([]interface{})
+	ints := make([]int64, 0, len(values))
+	for _, v := range values {
+		if n, ok := v.(json.Number); ok {
+			i, _ := n.Int64()
+			ints = append(ints, i)
+		}
+	}
+
+	return ints
+}
+
+// String returns the string parameter key, "" when it is missing.
+func (c Call) String(key string) string {
+	if v, ok := c.Params[key].(string); ok {
+		return v
+	}
+
+	return ""
+}
+
+type memberKey struct {
+	chatID int64
+	userID int64
+}
+
+type messageKey struct {
+	chatID    int64
+	messageID int64
+}
+
+// Bot is the user getMe returns.
+var Bot = models.User{ID: 123456, IsBot: true, FirstName: "Test bot", Username: "test_bot"}
+
+// Server implements getMe, sendMessage, deleteMessage, deleteMessages, getChatMember,
+// answerCallbackQuery, restrictChatMember and getUpdates. Other methods fail with 404 like
+// unknown methods of the real API.
+type Server struct {
+	*httptest.Server
+
+	// Webhook receives the updates passed to SendUpdate.
+	Webhook http.Handler
+	// WebhookSecret is sent as the X-Telegram-Bot-Api-Secret-Token header.
+	WebhookSecret string
+	// NoDeleteMessages answers deleteMessages with 404, like an older self-hosted server.
+	NoDeleteMessages bool
+
+	mu            sync.Mutex
+	calls         []Call
+	members       map[memberKey]string
+	messages      map[messageKey]bool
+	updates       []models.Update
+	nextUpdateID  int64
+	nextMessageID int64
+}
+
+// NewServer starts a fake server, callers must Close it.
+func NewServer() *Server {
+	s := &Server{
+		members:       map[memberKey]string{},
+		messages:      map[messageKey]bool{},
+		nextUpdateID:  1,
+		nextMessageID: 1000,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	return s
+}
+
+// SetMember sets the status getChatMember reports for the user, "left" by default.
+func (s *Server) SetMember(chatId int64, userId int64, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.members[memberKey{chatID: chatId, userID: userId}] = status
+}
+
+// Calls returns the requests received so far.
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Call{}, s.calls...)
+}
+
+// CallsTo returns the requests of one method.
+func (s *Server) CallsTo(method string) []Call {
+	calls := make([]Call, 0)
+	for _, call := range s.Calls() {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+
+	return calls
+}
+
+// Methods returns the names of the methods called so far, in order.
+func (s *Server) Methods() []string {
+	methods := make([]string, 0)
+	for _, call := range s.Calls() {
+		methods = append(methods, call.Method)
+	}
+
+	return methods
+}
+
+// Reset forgets the recorded calls.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls = nil
+}
+
+// HasMessage reports whether the message exists, it was sent or received and not deleted.
+func (s *Server) HasMessage(chatId int64, messageId int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.messages[messageKey{chatID: chatId, messageID: messageId}]
+}
+
+// QueueUpdate makes the update available to getUpdates and returns its update_id.
+func (s *Server) QueueUpdate(update models.Update) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	update.UpdateID = s.nextUpdateID
+	s.nextUpdateID++
+	s.rememberMessage(update)
+	s.updates = append(s.updates, update)
+
+	return update.UpdateID
+}
+
+// SendUpdate posts the update to the Webhook handler and fails unless it answers 200.
+func (s *Server) SendUpdate(update models.Update) error {
+	if s.Webhook == nil {
+		return fmt.Errorf("no webhook handler")
+	}
+
+	s.mu.Lock()
+	update.UpdateID = s.nextUpdateID
+	s.nextUpdateID++
+	s.rememberMessage(update)
+	s.mu.Unlock()
+
+	body, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Telegram-Bot-Api-Secret-Token", s.WebhookSecret)
+	w := httptest.NewRecorder()
+	s.Webhook.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		return fmt.Errorf("webhook answered %d: %s", w.Code, w.Body.String())
+	}
+
+	return nil
+}
+
+func (s *Server) rememberMessage(update models.Update) {
+	if update.Message != nil {
+		s.messages[messageKey{chatID: update.Message.Chat.ID, messageID: update.Message.MessageID}] = true
+	}
+}
+
+type response struct {
+	Ok          bool        `json:"ok"`
+	Result      interface{} `json:"result,omitempty"`
+	ErrorCode   int         `json:"error_code,omitempty"`
+	Description string      `json:"description,omitempty"`
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	method, ok := strings.CutPrefix(r.URL.Path, "/bot"+Token+"/")
+	if !ok {
+		writeResponse(w, response{ErrorCode: http.StatusUnauthorized, Description: "Unauthorized"})
+		return
+	}
+
+	call := Call{Method: method, Params: map[string]interface{}{}}
+	if r.Body != nil && r.ContentLength != 0 {
+		decoder := json.NewDecoder(r.Body)
+		decoder.UseNumber()
+		if err := decoder.Decode(&call.Params); err != nil {
+			writeResponse(w, response{ErrorCode: http.StatusBadRequest, Description: "Bad Request: " + err.Error()})
+			return
+		}
+		if call.Params == nil {
+			call.Params = map[string]interface{}{}
+		}
+	}
+	for key, values := range r.URL.Query() {
+		call.Params[key] = values[0]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls = append(s.calls, call)
+	writeResponse(w, s.handle(call))
+}
+
+func (s *Server) handle(call Call) response {
+	switch call.Method {
+	case "getMe":
+		return response{Ok: true, Result: Bot}
+	case "sendMessage":
+		s.nextMessageID++
+		message := models.Message{
+			MessageID:   s.nextMessageID,
+			Chat:        models.Chat{ID: call.Int("chat_id")},
+			Date:        time.Now().Unix(),
+			MessageText: call.String("text"),
+		}
+		s.messages[messageKey{chatID: message.Chat.ID, messageID: message.MessageID}] = true
+		return response{Ok: true, Result: message}
+	case "deleteMessage":
+		key := messageKey{chatID: call.Int("chat_id"), messageID: call.Int("message_id")}
+		if !s.messages[key] {
+			return response{ErrorCode: http.StatusBadRequest, Description: "Bad Request: message to delete not found"}
+		}
+		delete(s.messages, key)
+		return response{Ok: true, Result: true}
+	case "deleteMessages":
+		if s.NoDeleteMessages {
+			break
+		}
+		// messages that can't be found are skipped
+		for _, messageId := range call.Ints("message_ids") {
+			delete(s.messages, messageKey{chatID: call.Int("chat_id"), messageID: messageId})
+		}
+		return response{Ok: true, Result: true}
+	case "getChatMember":
+		userId := call.Int("user_id")
+		status, ok := s.members[memberKey{chatID: call.Int("chat_id"), userID: userId}]
+		if !ok {
+			status = "left"
+		}
+		return response{Ok: true, Result: models.ChatMember{User: models.User{ID: userId}, Status: status}}
+	case "answerCallbackQuery", "restrictChatMember":
+		return response{Ok: true, Result: true}
+	case "getUpdates":
+		// like the real API, asking for an offset confirms the earlier updates
+		offset := call.Int("offset")
+		pending := make([]models.Update, 0)
+		for _, update := range s.updates {
+			if update.UpdateID >= offset {
+				pending = append(pending, update)
+			}
+		}
+		s.updates = pending
+		return response{Ok: true, Result: pending}
+	}
+
+	return response{ErrorCode: http.StatusNotFound, Description: "Not Found"}
+}
+
+func writeResponse(w http.ResponseWriter, r response) {
+	status := http.StatusOK
+	if !r.Ok {
+		status = r.ErrorCode
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(r)
+}
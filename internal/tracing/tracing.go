@@ -0,0 +1,173 @@
+// internal/tracing/tracing.go
+
+// Package tracing routes the step by step trace of the moderation of a chat to sinks:
+// the log, a dedicated debug chat or an in-memory ring buffer.
+package tracing
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"telegram_moderator/internal/logging"
+	"telegram_moderator/internal/telegram"
+	"time"
+)
+
+// Event is one step of the moderation of a chat.
+type Event struct {
+	Time   time.Time `json:"time"`
+	ChatID int64     `json:"chat_id"`
+	Text   string    `json:"text"`
+}
+
+// Sink receives the events of the chats with tracing enabled.
+type Sink interface {
+	Trace(event Event)
+}
+
+// Tracer passes the events of the enabled chats to its sinks. The zero value traces nothing.
+type Tracer struct {
+	sinks []Sink
+
+	mu      sync.RWMutex
+	enabled map[int64]bool
+}
+
+func NewTracer(sinks ...Sink) *Tracer {
+	return &Tracer{sinks: sinks, enabled: map[int64]bool{}}
+}
+
+// SetEnabled turns tracing of the chat on or off.
+func (t *Tracer) SetEnabled(chatId int64, enabled bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.enabled == nil {
+		t.enabled = map[int64]bool{}
+	}
+	if enabled {
+		t.enabled[chatId] = true
+	} else {
+		delete(t.enabled, chatId)
+	}
+}
+
+func (t *Tracer) Enabled(chatId int64) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.enabled[chatId]
+}
+
+func (t *Tracer) Trace(chatId int64, text string) {
+	if t == nil || len(t.sinks) == 0 || !t.Enabled(chatId) {
+		return
+	}
+
+	event := Event{Time: time.Now(), ChatID: chatId, Text: text}
+	for _, sink := range t.sinks {
+		sink.Trace(event)
+	}
+}
+
+// LogSink writes the events to the log at debug level.
+type LogSink struct{}
+
+func (LogSink) Trace(event Event) {
+	slog.Debug("Trace", "chat_id", event.ChatID, logging.TraceKey, event.Text)
+}
+
+// chatSinkBuffer is how many events may wait for the debug chat, later ones are dropped
+const chatSinkBuffer = 100
+
+// ChatSink sends the events to a dedicated debug chat, never to the traced chat itself.
+// The events are sent in the background, so the moderation never waits for the rate limit of the debug chat.
+type ChatSink struct {
+	client  telegram.Client
+	chatId  int64
+	events  chan Event
+	dropped atomic.Int64
+}
+
+// NewChatSink starts the goroutine sending the events for the lifetime of the process.
+func NewChatSink(client telegram.Client, chatId int64) *ChatSink {
+	s := &ChatSink{client: client, chatId: chatId, events: make(chan Event, chatSinkBuffer)}
+	go s.run()
+
+	return s
+}
+
+// Trace queues the event, or drops it when the queue is full.
+func (s *ChatSink) Trace(event Event) {
+	select {
+	case s.events <- event:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+func (s *ChatSink) run() {
+	for event := range s.events {
+		text := fmt.Sprintf("[%d] %s", event.ChatID, event.Text)
+		if dropped := s.dropped.Swap(0); dropped > 0 {
+			text = fmt.Sprintf("(%d trace lines dropped)\n%s", dropped, text)
+		}
+
+		_, err := s.client.Call("sendMessage", map[string]interface{}{
+			"chat_id": s.chatId,
+			"text":    text,
+		})
+		if err != nil {
+			slog.Error("Error sending trace to the debug chat", "error", err)
+		}
+	}
+}
+
+// Ring keeps the last events in memory.
+type Ring struct {
+	mu     sync.Mutex
+	events []Event
+	next   int
+	full   bool
+}
+
+func NewRing(size int) *Ring {
+	return &Ring{events: make([]Event, size)}
+}
+
+func (r *Ring) Trace(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events[r.next] = event
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Events returns up to limit of the latest events of the chat, oldest first.
+// A chatId of zero returns the events of every chat.
+func (r *Ring) Events(chatId int64, limit int) []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ordered := r.events[:r.next]
+	if r.full {
+		ordered = append(append([]Event{}, r.events[r.next:]...), r.events[:r.next]...)
+	}
+
+	events := make([]Event, 0)
+	for _, event := range ordered {
+		if chatId == 0 || event.ChatID == chatId {
+			events = append(events, event)
+		}
+	}
+
+	if limit > 0 && len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+
+	return events
+}
@@ -0,0 +1,126 @@
+// internal/tracing/tracing_test.go
+
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTracerOnlyTracesEnabledChats(t *testing.T) {
+	ring := NewRing(10)
+	tracer := NewTracer(ring)
+
+	tracer.Trace(-100, "before")
+	tracer.SetEnabled(-100, true)
+	tracer.Trace(-100, "traced")
+	tracer.Trace(-200, "other chat")
+	tracer.SetEnabled(-100, false)
+	tracer.Trace(-100, "after")
+
+	events := ring.Events(0, 0)
+	if len(events) != 1 || events[0].Text != "traced" || events[0].ChatID != -100 {
+		t.Fatalf("events = %+v, want only the traced one", events)
+	}
+
+	var nilTracer *Tracer
+	nilTracer.Trace(-100, "ignored")
+}
+
+func TestRingEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		traced int
+		chatId int64
+		limit  int
+		want   []string
+	}{
+		{name: "empty", traced: 0, want: []string{}},
+		{name: "not full", traced: 3, want: []string{"0", "1", "2"}},
+		{name: "wrapped keeps the latest", traced: 6, want: []string{"2", "3", "4", "5"}},
+		{name: "limit", traced: 6, limit: 2, want: []string{"4", "5"}},
+		{name: "one chat", traced: 6, chatId: -1, want: []string{"3", "5"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ring := NewRing(4)
+			for i := 0; i < test.traced; i++ {
+				ring.Trace(Event{ChatID: int64(-(i % 2)), Text: fmt.Sprint(i)})
+			}
+
+			events := ring.Events(test.chatId, test.limit)
+			texts := make([]string, 0, len(events))
+			for _, event := range events {
+				texts = append(texts, event.Text)
+			}
+			if fmt.Sprint(texts) != fmt.Sprint(test.want) {
+				t.Fatalf("events = %v, want %v", texts, test.want)
+			}
+		})
+	}
+}
+
+// blockingClient records the sent texts, each call waits for release.
+type blockingClient struct {
+	called  chan struct{}
+	release chan struct{}
+	texts   chan string
+}
+
+func (c *blockingClient) Call(method string, params interface{}) (json.RawMessage, error) {
+	select {
+	case c.called <- struct{}{}:
+	default:
+	}
+	<-c.release
+	c.texts <- params.(map[string]interface{})["text"].(string)
+	return json.RawMessage(`{"message_id": 1}`), nil
+}
+
+func TestChatSinkDropsWhenFull(t *testing.T) {
+	client := &blockingClient{called: make(chan struct{}), release: make(chan struct{}), texts: make(chan string, 2*chatSinkBuffer)}
+	sink := NewChatSink(client, -999)
+
+	// the sender is stuck on the first event, the queue fills up and the rest is dropped
+	sink.Trace(Event{ChatID: -100, Text: "0"})
+	<-client.called
+	done := make(chan struct{})
+	go func() {
+		for i := 1; i < 2*chatSinkBuffer; i++ {
+			sink.Trace(Event{ChatID: -100, Text: fmt.Sprint(i)})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Trace waited for the debug chat")
+	}
+	close(client.release)
+
+	var texts []string
+	for len(texts) < chatSinkBuffer+1 {
+		select {
+		case text := <-client.texts:
+			texts = append(texts, text)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("sent %d traces, want %d", len(texts), chatSinkBuffer+1)
+		}
+	}
+
+	if texts[0] != "[-100] 0" {
+		t.Errorf("first trace = %q", texts[0])
+	}
+	if want := fmt.Sprintf("(%d trace lines dropped)", chatSinkBuffer-1); !strings.HasPrefix(texts[1], want) {
+		t.Errorf("second trace = %q, want the dropped count %q", texts[1], want)
+	}
+	select {
+	case text := <-client.texts:
+		t.Errorf("sent more than the queue holds: %q", text)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,133 @@
+// internal/verified/verified.go
+
+package verified
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"telegram_moderator/internal/storage"
+	"time"
+)
+
+const storeName = "verified_users"
+
+type User struct {
+	ChatID     int64     `json:"chat_id"`
+	UserID     int64     `json:"user_id"`
+	Username   string    `json:"username"`
+	FirstName  string    `json:"first_name"`
+	VerifiedAt time.Time `json:"verified_at"`
+	// ExpiresAt is zero when the verification never expires
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (u User) expired(now time.Time) bool {
+	return !u.ExpiresAt.IsZero() && !now.Before(u.ExpiresAt)
+}
+
+// Registry remembers users that passed the verification in a chat.
+type Registry struct {
+	mu    sync.Mutex
+	store *storage.Store
+	ttl   time.Duration
+	users map[string]User
+}
+
+// NewRegistry loads previously verified users from store. A ttl of zero keeps verifications forever.
+func NewRegistry(store *storage.Store, ttl time.Duration) (*Registry, error) {
+	r := &Registry{
+		store: store,
+		ttl:   ttl,
+		users: map[string]User{},
+	}
+
+	if err := store.Load(storeName, &r.users); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func key(chatId int64, userId int64) string {
+	return fmt.Sprintf("%d:%d", chatId, userId)
+}
+
+func (r *Registry) Add(chatId int64, userId int64, username string, firstName string, now time.Time) error {
+	user := User{
+		ChatID:     chatId,
+		UserID:     userId,
+		Username:   username,
+		FirstName:  firstName,
+		VerifiedAt: now,
+	}
+	if r.ttl > 0 {
+		user.ExpiresAt = now.Add(r.ttl)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.users[key(chatId, userId)] = user
+	return r.store.Save(storeName, r.users)
+}
+
+func (r *Registry) IsVerified(chatId int64, userId int64, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[key(chatId, userId)]
+	return ok && !user.expired(now)
+}
+
+// List returns the users with an active verification in the chat, most recent first.
+func (r *Registry) List(chatId int64, now time.Time) []User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users := make([]User, 0)
+	for _, user := range r.users {
+		if user.ChatID == chatId && !user.expired(now) {
+			users = append(users, user)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].VerifiedAt.After(users[j].VerifiedAt)
+	})
+
+	return users
+}
+
+// Revoke removes the verification and reports whether the user was verified.
+func (r *Registry) Revoke(chatId int64, userId int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[key(chatId, userId)]; !ok {
+		return false, nil
+	}
+
+	delete(r.users, key(chatId, userId))
+	return true, r.store.Save(storeName, r.users)
+}
+
+// Prune drops expired verifications so the store doesn't grow forever.
+func (r *Registry) Prune(now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pruned := false
+	for k, user := range r.users {
+		if user.expired(now) {
+			delete(r.users, k)
+			pruned = true
+		}
+	}
+
+	if !pruned {
+		return nil
+	}
+
+	return r.store.Save(storeName, r.users)
+}
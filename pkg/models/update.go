@@ -28,6 +28,10 @@ type CallbackQuery struct {
 type ChatMember struct {
 	User   User   `json:"user"`
 	Status string `json:"status"`
+	// rights of administrators
+	CanDeleteMessages  bool `json:"can_delete_messages,omitempty"`
+	CanRestrictMembers bool `json:"can_restrict_members,omitempty"`
+	CanPinMessages     bool `json:"can_pin_messages,omitempty"`
 }
 
 type Message struct {
@@ -39,12 +43,15 @@ type Message struct {
 	NewChatMembers []User          `json:"new_chat_members,omitempty"`
 	LeftChatMember *User           `json:"left_chat_member,omitempty"`
 	MessageText    string          `json:"text"`
+	Caption        string          `json:"caption,omitempty"`
+	MediaGroupID   string          `json:"media_group_id,omitempty"`
 	SenderChat     SenderChat      `json:"sender_chat"`
 	ReplyToMessage *ReplyToMessage `json:"reply_to_message"`
 }
 
 type ReplyToMessage struct {
 	MessageID int64 `json:"message_id"`
+	From      User  `json:"from"`
 }
 
 type SenderChat struct {
@@ -55,8 +62,18 @@ type SenderChat struct {
 }
 
 type User struct {
-	ID        int64  `json:"id"`
-	IsBot     bool   `json:"is_bot"`
-	FirstName string `json:"first_name"`
-	Username  string `json:"username"`
+	ID           int64  `json:"id"`
+	IsBot        bool   `json:"is_bot"`
+	FirstName    string `json:"first_name"`
+	Username     string `json:"username"`
+	LanguageCode string `json:"language_code"`
+}
+
+// Content is the text of the message, or the caption of a photo, video or album item.
+func (m *Message) Content() string {
+	if m.MessageText != "" {
+		return m.MessageText
+	}
+
+	return m.Caption
 }
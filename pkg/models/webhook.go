@@ -0,0 +1,15 @@
+// pkg/models/webhook.go
+
+package models
+
+// WebhookInfo is the result of getWebhookInfo.
+type WebhookInfo struct {
+	URL                          string `json:"url"`
+	HasCustomCertificate         bool   `json:"has_custom_certificate"`
+	PendingUpdateCount           int64  `json:"pending_update_count"`
+	IPAddress                    string `json:"ip_address,omitempty"`
+	LastErrorDate                int64  `json:"last_error_date,omitempty"`
+	LastErrorMessage             string `json:"last_error_message,omitempty"`
+	LastSynchronizationErrorDate int64  `json:"last_synchronization_error_date,omitempty"`
+	MaxConnections               int    `json:"max_connections,omitempty"`
+}